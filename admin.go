@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/joho/godotenv"
+)
+
+// isAdmin reports whether the user may run admin commands: either listed
+// in the configuration or added by another admin.
+func isAdmin(db *DB, cfg *Config, userID int64) bool {
+	return cfg.isAdmin(userID) || IsStoredAdmin(db, userID)
+}
+
+const adminCommandsHelp = `Команды администратора:
+/stats — статистика бота
+/user <id> — настройки и подписки пользователя
+/admins — список администраторов
+/admin_add <id>, /admin_del <id> — добавить или убрать администратора
+/post_run <id поста> — опубликовать пост канала сейчас
+/broadcast [city=Город] [sub=подписка] [lang=ru] текст — рассылка всем пользователям
+/reload — перечитать конфигурацию и шаблоны
+/channels — каналы и команды для них`
+
+// handleAdminCommand runs msg if it is an admin command sent by an admin.
+// It reports false otherwise so the message goes through the usual menus.
+func handleAdminCommand(ctx context.Context, db *DB, cfg *Config, msg *tgbotapi.Message) bool {
+	if msg.From == nil || !isAdmin(db, cfg, msg.From.ID) {
+		return false
+	}
+
+	chatID := msg.Chat.ID
+	args := strings.Fields(msg.CommandArguments())
+	reply := func(text string) {
+		post(tgbotapi.NewMessage(chatID, text))
+	}
+	idArg := func() (int64, bool) {
+		if len(args) != 1 {
+			return 0, false
+		}
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		return id, err == nil
+	}
+
+	switch msg.Command() {
+	case "admin":
+		reply(adminCommandsHelp)
+
+	case "stats":
+		reply(formatStats(db))
+
+	case "user":
+		id, ok := idArg()
+		if !ok {
+			reply("Использование: /user <id>")
+			return true
+		}
+		reply(formatUserInfo(db, id))
+
+	case "admins":
+		text := "Из конфигурации: " + joinIDs(cfg.Telegram.AdminIDs) + "\nДобавленные: " + joinIDs(ListAdmins(db))
+		reply(text)
+
+	case "admin_add":
+		id, ok := idArg()
+		if !ok {
+			reply("Использование: /admin_add <id>")
+			return true
+		}
+		AddAdmin(db, id)
+		reply("Администратор добавлен")
+
+	case "admin_del":
+		id, ok := idArg()
+		if !ok {
+			reply("Использование: /admin_del <id>")
+			return true
+		}
+		if !RemoveAdmin(db, id) {
+			reply("Такого администратора нет в базе (администраторов из конфигурации можно убрать только там)")
+			return true
+		}
+		reply("Администратор удалён")
+
+	case "post_run":
+		id, ok := idArg()
+		if !ok {
+			reply("Использование: /post_run <id поста>")
+			return true
+		}
+		ch, post, found := findChannelPost(db, id)
+		if !found {
+			reply("Пост не найден")
+			return true
+		}
+		inBackground(ctx, func(ctx context.Context) {
+			var err error
+			if post.Type == "live" {
+				err = updateLiveMessage(ctx, db, ch, post)
+			} else {
+				err = publishChannelPost(ctx, ch, post)
+			}
+			if err != nil {
+				reply("Не удалось опубликовать: " + err.Error())
+				return
+			}
+			reply("Опубликовано в " + ch.ChatID)
+		})
+
+	case "broadcast":
+		previewBroadcast(db, chatID, msg.CommandArguments())
+
+	case "reload":
+		if err := reloadConfig(db, cfg); err != nil {
+			reply("Ошибка перезагрузки: " + err.Error())
+			return true
+		}
+		reply("Конфигурация перечитана и применена")
+
+	default:
+		return handleChannelCommand(db, chatID, msg.Command(), args)
+	}
+	return true
+}
+
+// reloadConfig re-reads .env and the config file and applies it: admins,
+// templates, log level, schedule, cache lifetimes, API keys and quota,
+// and the default channel if there is none yet. Settings only read at
+// startup can't change this way; if any of them did, nothing is applied.
+func reloadConfig(db *DB, cfg *Config) error {
+	if err := godotenv.Overload(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	next, err := loadConfig(cfg.file, cfg.required)
+	if err != nil {
+		return err
+	}
+	if changed := cfg.restartOnly(next); len(changed) > 0 {
+		return fmt.Errorf("эти настройки меняются только перезапуском: %s", strings.Join(changed, ", "))
+	}
+	if err := loadTemplates(next.Templates.Dir); err != nil {
+		return err
+	}
+	*cfg = *next
+	setLogLevel(cfg.Log)
+	setSchedule(cfg.Schedule)
+	reconfigureProviders(cfg.Weather, cfg.Cache)
+	seedDefaultChannel(db, cfg.Channels)
+	return nil
+}
+
+func findChannelPost(db *DB, postID int64) (channelConfig, channelPost, bool) {
+	for _, ch := range ListChannels(db) {
+		for _, p := range ch.Posts {
+			if p.ID == postID {
+				return ch, p, true
+			}
+		}
+	}
+	return channelConfig{}, channelPost{}, false
+}
+
+func formatStats(db *DB) string {
+	total, withCity := CountUsers(db)
+	text := fmt.Sprintf("Пользователей: %d, с выбранным городом: %d\n", total, withCity)
+
+	counts := CountSubscriptions(db)
+	types := make([]string, 0, len(counts))
+	for t := range counts {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	text += "Подписки:\n"
+	for _, t := range types {
+		text += fmt.Sprintf("    %s: %d\n", t, counts[t])
+	}
+
+	channels := ListChannels(db)
+	posts := 0
+	for _, ch := range channels {
+		posts += len(ch.Posts)
+	}
+	text += fmt.Sprintf("Каналов: %d, постов: %d", len(channels), posts)
+	return text
+}
+
+func formatUserInfo(db *DB, userID int64) string {
+	city := GetUserCity(db, userID)
+	if city == "" {
+		city = "не выбран"
+	}
+	lang := GetUserLanguage(db, userID)
+	if lang == "" {
+		lang = "неизвестен"
+	}
+	text := fmt.Sprintf("Пользователь %d\nГород: %s\nЯзык: %s\n", userID, city, lang)
+
+	subs := GetUserSubscriptions(db, userID)
+	if len(subs) == 0 {
+		text += "Подписок нет\n"
+	} else {
+		text += "Подписки: " + strings.Join(subs, ", ")
+		if hour := GetCustomHour(db, userID); hour >= 0 {
+			text += fmt.Sprintf(" (своё время %d:00)", hour)
+		}
+		text += "\n"
+	}
+
+	s := GetAlertSettings(db, userID)
+	text += fmt.Sprintf("Оповещения: мороз %.0f°C, жара %.0f°C, порывы %.0f м/с, осадки %.0f мм, гроза %s, воздух %s\n",
+		s.Frost, s.Heat, s.Gust, s.Precip, onOff(s.Thunder), aqiThresholdLabel(s.AQI))
+	text += "Тихие часы: " + quietHoursLabel(db, userID) + "\n"
+	text += "Солнце и Луна в рассылке: " + onOff(GetAstroBlock(db, userID))
+	return text
+}
+
+func joinIDs(ids []int64) string {
+	if len(ids) == 0 {
+		return "нет"
+	}
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = strconv.FormatInt(id, 10)
+	}
+	return strings.Join(s, ", ")
+}
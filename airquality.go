@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// airSubscription is the sub_type of the daily air quality report.
+const airSubscription = "воздух"
+
+// airQuality is the common air quality model. AQI uses the European
+// 1 (good) to 5 (very poor) scale; pollutant concentrations are in μg/m³.
+type airQuality struct {
+	Time time.Time
+	AQI  int
+	PM25 float64
+	PM10 float64
+	O3   float64
+	NO2  float64
+}
+
+// airQualityProvider is a source of current air quality. It is separate
+// from weatherProvider so AQ data may come from a different service than
+// the forecast.
+type airQualityProvider interface {
+	AirQuality(ctx context.Context, lat, lon float64) (*airQuality, error)
+}
+
+var airProvider airQualityProvider
+
+func (p *owmProvider) AirQuality(ctx context.Context, lat, lon float64) (*airQuality, error) {
+	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/air_pollution?lat=%f&lon=%f", lat, lon)
+
+	var data struct {
+		List []struct {
+			Dt   int64 `json:"dt"`
+			Main struct {
+				AQI int `json:"aqi"`
+			} `json:"main"`
+			Components struct {
+				PM25 float64 `json:"pm2_5"`
+				PM10 float64 `json:"pm10"`
+				O3   float64 `json:"o3"`
+				NO2  float64 `json:"no2"`
+			} `json:"components"`
+		} `json:"list"`
+	}
+
+	if err := p.get(ctx, "air_pollution", url, &data); err != nil {
+		return nil, err
+	}
+
+	if len(data.List) == 0 {
+		return nil, fmt.Errorf("нет данных о качестве воздуха")
+	}
+
+	e := data.List[0]
+	return &airQuality{
+		Time: time.Unix(e.Dt, 0),
+		AQI:  e.Main.AQI,
+		PM25: e.Components.PM25,
+		PM10: e.Components.PM10,
+		O3:   e.Components.O3,
+		NO2:  e.Components.NO2,
+	}, nil
+}
+
+type cachedAirQuality struct {
+	next  airQualityProvider
+	cache *ttlCache[*airQuality]
+}
+
+func newCachedAirQuality(next airQualityProvider, ttl time.Duration) *cachedAirQuality {
+	return &cachedAirQuality{next: next, cache: newTTLCache[*airQuality]("air_quality", ttl)}
+}
+
+func airKey(lat, lon float64) string {
+	return fmt.Sprintf("%.2f,%.2f", lat, lon)
+}
+
+func (p *cachedAirQuality) AirQuality(ctx context.Context, lat, lon float64) (*airQuality, error) {
+	key := airKey(lat, lon)
+	if aq, ok := p.cache.Get(key); ok {
+		return aq, nil
+	}
+	aq, err := p.next.AirQuality(ctx, lat, lon)
+	if err != nil {
+		return nil, err
+	}
+	p.cache.Set(key, aq)
+	return aq, nil
+}
+
+// cachedAirQualityAt returns the air quality at the point if it is in the
+// cache and nil otherwise; it never calls the API.
+func cachedAirQualityAt(lat, lon float64) *airQuality {
+	p, ok := airProvider.(*cachedAirQuality)
+	if !ok {
+		return nil
+	}
+	aq, _ := p.cache.Get(airKey(lat, lon))
+	return aq
+}
+
+func aqiLabel(aqi int) string {
+	labels := []string{"хорошее", "удовлетворительное", "умеренное", "плохое", "очень плохое"}
+	if aqi < 1 || aqi > len(labels) {
+		return "нет данных"
+	}
+	return labels[aqi-1]
+}
+
+// aqiEmoji is the colour of the index; values outside 1..5 are shown as
+// missing data, like aqiLabel does.
+func aqiEmoji(aqi int) string {
+	switch aqi {
+	case 1, 2:
+		return "🟢"
+	case 3:
+		return "🟡"
+	case 4:
+		return "🟠"
+	case 5:
+		return "🔴"
+	}
+	return "⚪"
+}
+
+type airView struct {
+	City string
+	AQ   *airQuality
+}
+
+func getAirQuality(ctx context.Context, city string) (formatted, error) {
+	data, err := fetchForecast(ctx, city)
+	if err != nil {
+		return formatted{}, err
+	}
+
+	aq, err := airProvider.AirQuality(ctx, data.City.Coord.Lat, data.City.Coord.Lon)
+	if err != nil {
+		return formatted{}, err
+	}
+
+	return renderTemplate("air", airView{City: data.City.Name, AQ: aq})
+}
+
+func sendAirQualityToUsers(ctx context.Context, db *DB, users []int64) {
+	forEach(users, deliveryWorkers, func(userID int64) {
+		city := GetUserCity(db, userID)
+		if city == "" {
+			return
+		}
+		report, err := getAirQuality(ctx, city)
+		if err != nil {
+			slog.Warn("Ошибка получения качества воздуха", "job", "subscriptions", "subscription", airSubscription, "chat_id", userID, "city", city, "err", err)
+		} else {
+			_, err = send(report.message(userID))
+		}
+		countDelivery("air", err)
+	})
+}
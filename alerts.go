@@ -0,0 +1,369 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// alertSubscription is the sub_type of users who receive severe weather
+// alerts. Thresholds live in the alert_settings table.
+const alertSubscription = "оповещения"
+
+const (
+	alertLookahead = 24 * time.Hour
+	alertRetention = 72 * time.Hour
+)
+
+// AlertSettings are the per-user thresholds of the alert engine. Precip is
+// the amount of rain or snow in mm per 3-hour forecast step. AQI is the air
+// quality index (1-5) from which to warn, 0 disables air quality alerts.
+type AlertSettings struct {
+	Frost   float64
+	Heat    float64
+	Gust    float64
+	Precip  float64
+	Thunder bool
+	AQI     int
+}
+
+var defaultAlertSettings = AlertSettings{
+	Frost:   -5,
+	Heat:    30,
+	Gust:    15,
+	Precip:  10,
+	Thunder: true,
+}
+
+// alertEvent is a condition found in the forecast. Key identifies the
+// event for deduplication: the same kind on the same local date is
+// announced only once. At and Value are the first time and the worst
+// value of the kind; air alerts carry the index in AQ instead.
+type alertEvent struct {
+	Key   string
+	Kind  string
+	At    time.Time
+	Value float64
+	AQ    *airQuality
+}
+
+// alertView is the data of the "alert" template.
+type alertView struct {
+	City   string
+	Events []alertEvent
+}
+
+var awaitingAlertInput = make(map[int64]string)
+
+// evaluateAlerts checks the forecast entries of the next alertLookahead
+// against the user's thresholds.
+func evaluateAlerts(data *forecastResponse, s AlertSettings, now time.Time) []alertEvent {
+	loc := data.location()
+	type match struct {
+		at    time.Time
+		value float64
+	}
+	found := make(map[string]*match)
+	var order []string
+
+	note := func(kind string, at time.Time, value float64, worse func(a, b float64) bool) {
+		m, ok := found[kind]
+		if !ok {
+			found[kind] = &match{at: at, value: value}
+			order = append(order, kind)
+			return
+		}
+		if worse(value, m.value) {
+			m.value = value
+		}
+	}
+	lower := func(a, b float64) bool { return a < b }
+	higher := func(a, b float64) bool { return a > b }
+
+	for _, e := range data.List {
+		t := time.Unix(e.Dt, 0).In(loc)
+		if t.Add(forecastStep).Before(now) || t.After(now.Add(alertLookahead)) {
+			continue
+		}
+		if e.Main.Temp <= s.Frost {
+			note("frost", t, e.Main.Temp, lower)
+		}
+		if e.Main.Temp >= s.Heat {
+			note("heat", t, e.Main.Temp, higher)
+		}
+		if g := e.gust(); g >= s.Gust {
+			note("gust", t, g, higher)
+		}
+		if e.Rain.Volume >= s.Precip {
+			note("rain", t, e.Rain.Volume, higher)
+		}
+		if e.Snow.Volume >= s.Precip {
+			note("snow", t, e.Snow.Volume, higher)
+		}
+		if s.Thunder && e.thunderstorm() {
+			note("thunder", t, 0, higher)
+		}
+	}
+
+	events := make([]alertEvent, 0, len(order))
+	for _, kind := range order {
+		m := found[kind]
+		events = append(events, alertEvent{Key: kind + ":" + m.at.Format("2006-01-02"), Kind: kind, At: m.at, Value: m.value})
+	}
+	return events
+}
+
+// evaluateAirAlert warns users sensitive to air pollution once a day when
+// the current index reaches their threshold.
+func evaluateAirAlert(aq *airQuality, s AlertSettings, now time.Time) (alertEvent, bool) {
+	if aq == nil || s.AQI <= 0 || aq.AQI < s.AQI {
+		return alertEvent{}, false
+	}
+	return alertEvent{Key: "air:" + now.Format("2006-01-02"), Kind: "air", At: now, AQ: aq}, true
+}
+
+func startAlertScheduler(ctx context.Context, db *DB) {
+	interval := func() time.Duration { return currentSchedule().AlertInterval }
+	runEvery(ctx, "alerts", interval, func(ctx context.Context) { checkAlerts(ctx, db) })
+}
+
+// checkAlerts evaluates the forecast for every location with alert
+// subscribers, fetching each city once per run.
+func checkAlerts(ctx context.Context, db *DB) {
+	PruneSentAlerts(db, time.Now().Add(-alertRetention))
+
+	byCity := make(map[string][]int64)
+	for _, userID := range GetSubscribers(db, alertSubscription) {
+		city := GetUserCity(db, userID)
+		if city == "" {
+			continue
+		}
+		byCity[city] = append(byCity[city], userID)
+	}
+
+	now := time.Now()
+	for city, users := range byCity {
+		data, err := fetchForecast(ctx, city)
+		if err != nil {
+			slog.Warn("Ошибка получения прогноза", "job", "alerts", "city", city, "err", err)
+			continue
+		}
+		// Air quality is fetched once per city and only if someone needs
+		// it; after a failure the AQ part is skipped for the whole city.
+		var aq *airQuality
+		aqFetched := false
+		for _, userID := range users {
+			settings := GetAlertSettings(db, userID)
+			events := evaluateAlerts(data, settings, now)
+			if settings.AQI > 0 {
+				if !aqFetched {
+					aqFetched = true
+					aq, err = airProvider.AirQuality(ctx, data.City.Coord.Lat, data.City.Coord.Lon)
+					if err != nil {
+						slog.Warn("Ошибка получения качества воздуха", "job", "alerts", "city", city, "err", err)
+					}
+				}
+				if ev, ok := evaluateAirAlert(aq, settings, now.In(data.location())); ok {
+					events = append(events, ev)
+				}
+			}
+
+			view := alertView{City: data.City.Name}
+			for _, ev := range events {
+				if MarkAlertSent(db, userID, ev.Key) {
+					view.Events = append(view.Events, ev)
+				}
+			}
+			if len(view.Events) == 0 {
+				continue
+			}
+			msg, err := renderTemplate("alert", view)
+			if err != nil {
+				slog.Error("Ошибка шаблона", "job", "alerts", "chat_id", userID, "err", err)
+			} else {
+				_, err = send(msg.message(userID))
+			}
+			countDelivery("alert", err)
+		}
+	}
+}
+
+func hasSubscription(db *DB, chatID int64, subType string) bool {
+	for _, sub := range GetUserSubscriptions(db, chatID) {
+		if sub == subType {
+			return true
+		}
+	}
+	return false
+}
+
+func aqiThresholdLabel(aqi int) string {
+	if aqi <= 0 {
+		return "выкл"
+	}
+	return fmt.Sprintf("от AQI %d (%s)", aqi, aqiLabel(aqi))
+}
+
+func onOff(v bool) string {
+	if v {
+		return "вкл"
+	}
+	return "выкл"
+}
+
+func showAlertsMenu(db *DB, chatID int64) {
+	setMenu(chatID, "alerts")
+	s := GetAlertSettings(db, chatID)
+	enabled := hasSubscription(db, chatID, alertSubscription)
+	rainSoon := hasSubscription(db, chatID, rainSubscription)
+
+	text := fmt.Sprintf("❗ Оповещения: %s\n"+
+		"🥶 Мороз: ниже %.0f°C\n"+
+		"🔥 Жара: выше %.0f°C\n"+
+		"💨 Порывы: от %.0f м/с\n"+
+		"🌧 Осадки: от %.1f мм за 3 часа\n"+
+		"⛈ Гроза: %s\n"+
+		"🌫 Воздух: %s\n"+
+		"☂️ Дождь скоро: %s\n"+
+		"🌙 Тихие часы: %s",
+		onOff(enabled), s.Frost, s.Heat, s.Gust, s.Precip, onOff(s.Thunder),
+		aqiThresholdLabel(s.AQI), onOff(rainSoon), quietHoursLabel(db, chatID))
+
+	toggle := "✅ Включить оповещения"
+	if enabled {
+		toggle = "🔕 Выключить оповещения"
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(toggle),
+		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("🥶 Мороз"),
+			tgbotapi.NewKeyboardButton("🔥 Жара"),
+		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("💨 Порывы"),
+			tgbotapi.NewKeyboardButton("🌧 Осадки"),
+		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("⛈ Гроза"),
+			tgbotapi.NewKeyboardButton("☂️ Дождь скоро"),
+		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("🌫 Воздух"),
+			tgbotapi.NewKeyboardButton("🌙 Тихие часы"),
+		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("🔙 Назад"),
+		),
+	)
+	post(msg)
+}
+
+func handleAlertsMenu(db *DB, chatID int64, text string) {
+	switch text {
+	case "✅ Включить оповещения":
+		SetSubscription(db, chatID, alertSubscription)
+		showAlertsMenu(db, chatID)
+
+	case "🔕 Выключить оповещения":
+		UnsetSpecificSubscription(db, chatID, alertSubscription)
+		showAlertsMenu(db, chatID)
+
+	case "🥶 Мороз":
+		awaitingAlertInput[chatID] = "frost"
+		post(tgbotapi.NewMessage(chatID, "Введите температуру в °C, ниже которой предупреждать о морозе"))
+
+	case "🔥 Жара":
+		awaitingAlertInput[chatID] = "heat"
+		post(tgbotapi.NewMessage(chatID, "Введите температуру в °C, выше которой предупреждать о жаре"))
+
+	case "💨 Порывы":
+		awaitingAlertInput[chatID] = "gust"
+		post(tgbotapi.NewMessage(chatID, "Введите скорость порывов ветра в м/с"))
+
+	case "🌧 Осадки":
+		awaitingAlertInput[chatID] = "precip"
+		post(tgbotapi.NewMessage(chatID, "Введите количество осадков в мм за 3 часа"))
+
+	case "🌫 Воздух":
+		awaitingAlertInput[chatID] = "aqi"
+		post(tgbotapi.NewMessage(chatID, "Введите индекс качества воздуха от 1 (хорошее) до 5 (очень плохое), начиная с которого предупреждать, или 0, чтобы отключить"))
+
+	case "⛈ Гроза":
+		s := GetAlertSettings(db, chatID)
+		s.Thunder = !s.Thunder
+		SetAlertSettings(db, chatID, s)
+		showAlertsMenu(db, chatID)
+
+	case "☂️ Дождь скоро":
+		if hasSubscription(db, chatID, rainSubscription) {
+			UnsetSpecificSubscription(db, chatID, rainSubscription)
+			ClearRainEpisode(db, chatID)
+		} else {
+			SetSubscription(db, chatID, rainSubscription)
+		}
+		showAlertsMenu(db, chatID)
+
+	case "🌙 Тихие часы":
+		awaitingQuietHours[chatID] = true
+		post(tgbotapi.NewMessage(chatID, "Введите тихие часы в формате 22-7 (по местному времени города) или «нет», чтобы отключить"))
+
+	case "🔙 Назад":
+		showMainMenu(chatID)
+
+	default:
+		post(tgbotapi.NewMessage(chatID, "Выберите вариант из меню."))
+	}
+}
+
+// handleAlertInput applies a threshold typed by the user after choosing it
+// in the alerts menu.
+func handleAlertInput(db *DB, chatID int64, text string) {
+	value, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(text), ",", ".", 1), 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		post(tgbotapi.NewMessage(chatID, "Введите число, например 25 или -7.5"))
+		return
+	}
+	if value < -80 || value > 80 {
+		post(tgbotapi.NewMessage(chatID, "Значение вне допустимого диапазона"))
+		return
+	}
+
+	s := GetAlertSettings(db, chatID)
+	switch awaitingAlertInput[chatID] {
+	case "frost":
+		s.Frost = value
+	case "heat":
+		s.Heat = value
+	case "gust":
+		if value <= 0 {
+			post(tgbotapi.NewMessage(chatID, "Скорость ветра должна быть больше нуля"))
+			return
+		}
+		s.Gust = value
+	case "precip":
+		if value <= 0 {
+			post(tgbotapi.NewMessage(chatID, "Количество осадков должно быть больше нуля"))
+			return
+		}
+		s.Precip = value
+	case "aqi":
+		if value != math.Trunc(value) || value < 0 || value > 5 {
+			post(tgbotapi.NewMessage(chatID, "Индекс должен быть целым числом от 0 до 5"))
+			return
+		}
+		s.AQI = int(value)
+	}
+	delete(awaitingAlertInput, chatID)
+	SetAlertSettings(db, chatID, s)
+	showAlertsMenu(db, chatID)
+}
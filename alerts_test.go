@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestEvaluateAlerts(t *testing.T) {
+	// now is 03:00 local time on 13 May at UTC+3.
+	now := weatherStart
+	mild := func(offset time.Duration) forecastEntry {
+		return testEntry(offset, 15, 0, "облачно")
+	}
+	with := func(offset time.Duration, change func(e *forecastEntry)) forecastEntry {
+		e := mild(offset)
+		change(&e)
+		return e
+	}
+	temp := func(v float64) func(e *forecastEntry) {
+		return func(e *forecastEntry) { e.Main.Temp = v }
+	}
+
+	for _, tc := range []struct {
+		name     string
+		settings func(s *AlertSettings)
+		entries  []forecastEntry
+		want     []string // key value@local time
+	}{
+		{
+			name:    "calm",
+			entries: []forecastEntry{mild(0), mild(3 * time.Hour)},
+		},
+		{
+			name: "frost once with the worst value",
+			entries: []forecastEntry{
+				mild(0),
+				with(3*time.Hour, temp(-6)),
+				with(6*time.Hour, temp(-9)),
+				with(9*time.Hour, temp(-7)),
+			},
+			want: []string{"frost:2024-05-13 -9@06:00"},
+		},
+		{
+			name: "kinds in the order found",
+			entries: []forecastEntry{
+				with(0, func(e *forecastEntry) { e.Wind.Speed, e.Wind.Gust = 8, 18 }),
+				with(3*time.Hour, func(e *forecastEntry) { e.Rain.Volume = 12 }),
+				with(6*time.Hour, func(e *forecastEntry) { e.Snow.Volume = 10 }),
+				with(9*time.Hour, func(e *forecastEntry) { e.Condition = conditionThunderstorm }),
+				with(12*time.Hour, temp(31)),
+			},
+			want: []string{
+				"gust:2024-05-13 18@03:00",
+				"rain:2024-05-13 12@06:00",
+				"snow:2024-05-13 10@09:00",
+				"thunder:2024-05-13 0@12:00",
+				"heat:2024-05-13 31@15:00",
+			},
+		},
+		{
+			name:     "thunder turned off",
+			settings: func(s *AlertSettings) { s.Thunder = false },
+			entries:  []forecastEntry{with(0, func(e *forecastEntry) { e.Condition = conditionThunderstorm })},
+		},
+		{
+			name:     "own thresholds",
+			settings: func(s *AlertSettings) { s.Heat = 14 },
+			entries:  []forecastEntry{mild(0)},
+			want:     []string{"heat:2024-05-13 15@03:00"},
+		},
+		{
+			name: "key is the date of the first match",
+			entries: []forecastEntry{
+				with(21*time.Hour, temp(-6)), // 00:00 on the 14th
+				with(24*time.Hour, temp(-8)),
+			},
+			want: []string{"frost:2024-05-14 -8@00:00"},
+		},
+		{
+			name: "outside the lookahead",
+			entries: []forecastEntry{
+				with(-4*time.Hour, temp(-10)), // over before now
+				with(-3*time.Hour, temp(-6)),  // ends at now
+				with(alertLookahead+3*time.Hour, temp(-20)),
+			},
+			want: []string{"frost:2024-05-13 -6@00:00"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := defaultAlertSettings
+			if tc.settings != nil {
+				tc.settings(&s)
+			}
+			data := &forecastResponse{List: tc.entries}
+			data.City.Timezone = 3 * 3600
+			var got []string
+			for _, e := range evaluateAlerts(data, s, now) {
+				got = append(got, fmt.Sprintf("%s %g@%s", e.Key, e.Value, e.At.Format("15:04")))
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateAirAlert(t *testing.T) {
+	now := time.Date(2024, 5, 13, 8, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name      string
+		aq        *airQuality
+		threshold int
+		want      bool
+	}{
+		{"no data", nil, 3, false},
+		{"turned off", &airQuality{AQI: 5}, 0, false},
+		{"below", &airQuality{AQI: 2}, 3, false},
+		{"at the threshold", &airQuality{AQI: 3}, 3, true},
+		{"above", &airQuality{AQI: 5}, 3, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := defaultAlertSettings
+			s.AQI = tc.threshold
+			ev, ok := evaluateAirAlert(tc.aq, s, now)
+			if ok != tc.want {
+				t.Fatalf("alert %v, want %v", ok, tc.want)
+			}
+			if ok && (ev.Key != "air:2024-05-13" || ev.AQ != tc.aq) {
+				t.Errorf("got %+v", ev)
+			}
+		})
+	}
+}
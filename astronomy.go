@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"time"
+)
+
+// Solar altitudes of the horizon events: sunrise/sunset account for
+// refraction and the solar disc, civil twilight ends at 6° below horizon.
+const (
+	sunriseAltitude  = -0.833
+	civilTwilightAlt = -6.0
+
+	julianUnixEpoch = 2440587.5
+	julian2000      = 2451545.0
+	synodicMonth    = 29.530588853
+	// knownNewMoon is the Julian date of the new moon of 6 January 2000.
+	knownNewMoon = 2451550.1
+)
+
+// sunEvents holds the times the sun crosses an altitude on a local date.
+// Polar is "day" when the sun stays above that altitude all day and
+// "night" when it never reaches it.
+type sunEvents struct {
+	Rise, Set time.Time
+	Polar     string
+}
+
+func (e sunEvents) Length() time.Duration {
+	switch e.Polar {
+	case "day":
+		return 24 * time.Hour
+	case "night":
+		return 0
+	}
+	return e.Set.Sub(e.Rise)
+}
+
+func toJulian(t time.Time) float64 {
+	return float64(t.Unix())/86400 + julianUnixEpoch
+}
+
+func fromJulian(jd float64, loc *time.Location) time.Time {
+	sec := (jd - julianUnixEpoch) * 86400
+	return time.Unix(int64(math.Round(sec)), 0).In(loc)
+}
+
+func sinDeg(x float64) float64 { return math.Sin(x * math.Pi / 180) }
+func cosDeg(x float64) float64 { return math.Cos(x * math.Pi / 180) }
+
+// sunAt computes when the sun crosses altitude on the given local date
+// using the sunrise equation (NOAA-style approximation, accurate to about
+// a minute outside polar regions).
+func sunAt(date time.Time, lat, lon, altitude float64) sunEvents {
+	loc := date.Location()
+	noon := time.Date(date.Year(), date.Month(), date.Day(), 12, 0, 0, 0, loc)
+
+	n := math.Round(toJulian(noon) - julian2000 + 0.0008)
+	jStar := n - lon/360
+	m := math.Mod(357.5291+0.98560028*jStar, 360)
+	c := 1.9148*sinDeg(m) + 0.02*sinDeg(2*m) + 0.0003*sinDeg(3*m)
+	lambda := math.Mod(m+c+180+102.9372, 360)
+	transit := julian2000 + jStar + 0.0053*sinDeg(m) - 0.0069*sinDeg(2*lambda)
+
+	sinDecl := sinDeg(lambda) * sinDeg(23.4397)
+	cosDecl := math.Cos(math.Asin(sinDecl))
+	cosOmega := (sinDeg(altitude) - sinDeg(lat)*sinDecl) / (cosDeg(lat) * cosDecl)
+
+	switch {
+	case cosOmega < -1:
+		return sunEvents{Polar: "day"}
+	case cosOmega > 1:
+		return sunEvents{Polar: "night"}
+	}
+
+	omega := math.Acos(cosOmega) * 180 / math.Pi
+	return sunEvents{
+		Rise: fromJulian(transit-omega/360, loc),
+		Set:  fromJulian(transit+omega/360, loc),
+	}
+}
+
+// moonPhase returns the moon's age in days since the last new moon and the
+// illuminated fraction of its disc.
+func moonPhase(t time.Time) (age, illumination float64) {
+	cycles := (toJulian(t) - knownNewMoon) / synodicMonth
+	frac := cycles - math.Floor(cycles)
+	return frac * synodicMonth, (1 - math.Cos(2*math.Pi*frac)) / 2
+}
+
+func moonPhaseName(age float64) string {
+	names := []string{
+		"🌑 новолуние", "🌒 растущий серп", "🌓 первая четверть", "🌔 растущая луна",
+		"🌕 полнолуние", "🌖 убывающая луна", "🌗 последняя четверть", "🌘 убывающий серп",
+	}
+	i := int(math.Floor(age/synodicMonth*8+0.5)) % 8
+	return names[i]
+}
+
+func uvLabel(uvi float64) string {
+	switch {
+	case uvi < 3:
+		return "низкий"
+	case uvi < 6:
+		return "умеренный"
+	case uvi < 8:
+		return "высокий"
+	case uvi < 11:
+		return "очень высокий"
+	}
+	return "экстремальный"
+}
+
+func formatDayLength(d time.Duration) string {
+	d = d.Round(time.Minute)
+	return fmt.Sprintf("%d ч %02d мин", int(d.Hours()), int(d.Minutes())%60)
+}
+
+func formatDayLengthChange(d time.Duration) string {
+	sign := "+"
+	if d < 0 {
+		sign = "−"
+		d = -d
+	}
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%s%d мин %02d с", sign, int(d.Minutes()), int(d.Seconds())%60)
+}
+
+// astroView is the sun and moon data for one local date. Everything
+// except the UV index is computed locally.
+type astroView struct {
+	City         string
+	Date         time.Time
+	Sun          sunEvents
+	Twilight     sunEvents
+	LengthChange time.Duration
+	MoonAge      float64
+	Illumination float64
+	UV           float64
+	HasUV        bool
+}
+
+func astronomyAt(ctx context.Context, city string, lat, lon float64, now time.Time) *astroView {
+	today := sunAt(now, lat, lon, sunriseAltitude)
+	yesterday := sunAt(now.AddDate(0, 0, -1), lat, lon, sunriseAltitude)
+
+	v := &astroView{
+		City:         city,
+		Date:         now,
+		Sun:          today,
+		Twilight:     sunAt(now, lat, lon, civilTwilightAlt),
+		LengthChange: today.Length() - yesterday.Length(),
+	}
+	v.MoonAge, v.Illumination = moonPhase(now)
+	switch uvi, err := provider.UVIndex(ctx, lat, lon); {
+	case err == nil:
+		v.UV, v.HasUV = uvi, true
+	case !errors.Is(err, errNotSupported):
+		slog.Warn("Ошибка получения УФ-индекса", "city", city, "err", err)
+	}
+	return v
+}
+
+func astronomyData(ctx context.Context, city string) (*astroView, error) {
+	data, err := fetchForecast(ctx, city)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().In(data.location())
+	return astronomyAt(ctx, data.City.Name, data.City.Coord.Lat, data.City.Coord.Lon, now), nil
+}
+
+func getAstronomy(ctx context.Context, city string) (formatted, error) {
+	v, err := astronomyData(ctx, city)
+	if err != nil {
+		return formatted{}, err
+	}
+	return renderTemplate("astro", v)
+}
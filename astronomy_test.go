@@ -0,0 +1,77 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSunAt(t *testing.T) {
+	london, _ := time.LoadLocation("Europe/London")
+	moscow, _ := time.LoadLocation("Europe/Moscow")
+	oslo, _ := time.LoadLocation("Europe/Oslo")
+	// Published sunrise and sunset times, local clock.
+	for _, tc := range []struct {
+		name      string
+		date      time.Time
+		lat, lon  float64
+		rise, set string
+		polar     string
+	}{
+		{"London, summer solstice", time.Date(2024, 6, 21, 0, 0, 0, 0, london), 51.5074, -0.1278, "04:43", "21:21", ""},
+		{"London, equinox", time.Date(2024, 3, 20, 0, 0, 0, 0, london), 51.5074, -0.1278, "06:02", "18:14", ""},
+		{"Moscow, winter solstice", time.Date(2024, 12, 21, 0, 0, 0, 0, moscow), 55.7558, 37.6173, "08:58", "15:57", ""},
+		{"Tromsø, midnight sun", time.Date(2024, 6, 21, 0, 0, 0, 0, oslo), 69.65, 18.96, "", "", "day"},
+		{"Tromsø, polar night", time.Date(2024, 12, 21, 0, 0, 0, 0, oslo), 69.65, 18.96, "", "", "night"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			e := sunAt(tc.date, tc.lat, tc.lon, sunriseAltitude)
+			if e.Polar != tc.polar {
+				t.Fatalf("polar %q, want %q", e.Polar, tc.polar)
+			}
+			if tc.polar != "" {
+				return
+			}
+			for _, c := range []struct {
+				name string
+				got  time.Time
+				want string
+			}{{"sunrise", e.Rise, tc.rise}, {"sunset", e.Set, tc.set}} {
+				want, err := time.ParseInLocation("2006-01-02 15:04", tc.date.Format("2006-01-02 ")+c.want, tc.date.Location())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if d := c.got.Sub(want); d < -2*time.Minute || d > 2*time.Minute {
+					t.Errorf("%s at %s, want %s", c.name, c.got.Format("15:04:05"), c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMoonPhase(t *testing.T) {
+	// Moon phases of January 2024, UTC.
+	for _, tc := range []struct {
+		at           string
+		illumination float64
+		name         string
+	}{
+		{"2024-01-11T11:57:00Z", 0, "🌑 новолуние"},
+		{"2024-01-18T03:52:00Z", 0.5, "🌓 первая четверть"},
+		{"2024-01-25T17:54:00Z", 1, "🌕 полнолуние"},
+		{"2024-02-02T23:18:00Z", 0.5, "🌗 последняя четверть"},
+	} {
+		at, err := time.Parse(time.RFC3339, tc.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		age, illumination := moonPhase(at)
+		// The mean synodic month is off the real phases by up to a day.
+		if math.Abs(illumination-tc.illumination) > 0.1 {
+			t.Errorf("%s: illumination %.2f, want about %.2f", tc.at, illumination, tc.illumination)
+		}
+		if got := moonPhaseName(age); got != tc.name {
+			t.Errorf("%s: %s (age %.1f days), want %s", tc.at, got, age, tc.name)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// broadcastRate stays just under Telegram's limit of about 30 messages per
+// second to different chats.
+const (
+	broadcastRate          = 25
+	broadcastProgressEvery = 5 * time.Second
+)
+
+// broadcastFilter narrows the audience of an announcement; empty fields
+// match everyone.
+type broadcastFilter struct {
+	City    string
+	SubType string
+	Lang    string
+}
+
+func (f broadcastFilter) String() string {
+	var parts []string
+	if f.City != "" {
+		parts = append(parts, "город "+f.City)
+	}
+	if f.SubType != "" {
+		parts = append(parts, "подписка "+f.SubType)
+	}
+	if f.Lang != "" {
+		parts = append(parts, "язык "+f.Lang)
+	}
+	if len(parts) == 0 {
+		return "все пользователи"
+	}
+	return strings.Join(parts, ", ")
+}
+
+type broadcast struct {
+	Filter broadcastFilter
+	Text   string
+	Users  []int64
+}
+
+// pendingBroadcasts holds announcements shown to an admin as a preview and
+// waiting for confirmation.
+var pendingBroadcasts = make(map[int64]*broadcast)
+
+var broadcastRunning atomic.Bool
+
+const broadcastUsage = `Использование: /broadcast [city=Город] [sub=подписка] [lang=ru] текст
+Фильтры необязательны, пробелы в городе заменяйте на _`
+
+// parseBroadcast splits the leading key=value filters from the text.
+func parseBroadcast(args string) (broadcastFilter, string, error) {
+	var f broadcastFilter
+	rest := strings.TrimLeftFunc(args, unicode.IsSpace)
+	for rest != "" {
+		end := strings.IndexFunc(rest, unicode.IsSpace)
+		if end < 0 {
+			end = len(rest)
+		}
+		key, value, ok := strings.Cut(rest[:end], "=")
+		if !ok {
+			break
+		}
+		switch key {
+		case "city":
+			f.City = strings.ReplaceAll(value, "_", " ")
+		case "sub":
+			f.SubType = value
+		case "lang":
+			f.Lang = value
+		default:
+			return f, "", fmt.Errorf("неизвестный фильтр %q", key)
+		}
+		rest = strings.TrimLeftFunc(rest[end:], unicode.IsSpace)
+	}
+	return f, strings.TrimSpace(rest), nil
+}
+
+// previewBroadcast shows the admin the announcement and its audience and
+// asks for confirmation.
+func previewBroadcast(db *DB, chatID int64, args string) {
+	filter, text, err := parseBroadcast(args)
+	if err != nil {
+		post(tgbotapi.NewMessage(chatID, err.Error()+"\n\n"+broadcastUsage))
+		return
+	}
+	if text == "" {
+		post(tgbotapi.NewMessage(chatID, broadcastUsage))
+		return
+	}
+
+	b := &broadcast{Filter: filter, Text: text, Users: GetBroadcastAudience(db, filter.City, filter.SubType, filter.Lang)}
+	pendingBroadcasts[chatID] = b
+
+	post(tgbotapi.NewMessage(chatID, text))
+	msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("👆 Так будет выглядеть рассылка.\nПолучатели: %s — %d чел.", filter, len(b.Users)))
+	msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("✅ Отправить"),
+			tgbotapi.NewKeyboardButton("❌ Отмена"),
+		),
+	)
+	post(msg)
+}
+
+// handleBroadcastConfirm handles the answer to a broadcast preview.
+func handleBroadcastConfirm(ctx context.Context, chatID int64, text string) {
+	b := pendingBroadcasts[chatID]
+	delete(pendingBroadcasts, chatID)
+
+	if text != "✅ Отправить" {
+		post(tgbotapi.NewMessage(chatID, "Рассылка отменена"))
+		showMainMenu(chatID)
+		return
+	}
+	if !broadcastRunning.CompareAndSwap(false, true) {
+		post(tgbotapi.NewMessage(chatID, "Уже идёт другая рассылка, попробуйте позже"))
+		showMainMenu(chatID)
+		return
+	}
+	showMainMenu(chatID)
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		defer broadcastRunning.Store(false)
+		runBroadcast(ctx, chatID, b)
+	}()
+}
+
+// runBroadcast delivers the announcement at broadcastRate messages per
+// second and keeps a progress message up to date for the admin. Users who
+// blocked the bot are marked inactive by the outbox. On shutdown it stops
+// handing out new messages and reports how far it got.
+func runBroadcast(ctx context.Context, adminID int64, b *broadcast) {
+	progress, err := send(tgbotapi.NewMessage(adminID, fmt.Sprintf("📣 Рассылка: 0 из %d", len(b.Users))))
+	if err != nil {
+		slog.Warn("Ошибка отправки прогресса рассылки", "job", "broadcast", "chat_id", adminID, "err", err)
+	}
+	report := func(done, sent, blocked, failed int) string {
+		return fmt.Sprintf("📣 Рассылка: %d из %d\nДоставлено: %d\nЗаблокировали бота: %d\nОшибки: %d",
+			done, len(b.Users), sent, blocked, failed)
+	}
+
+	ticker := time.NewTicker(time.Second / broadcastRate)
+	defer ticker.Stop()
+
+	// Messages are handed to the outbox at broadcastRate without waiting
+	// for each one, so slow responses don't stretch the broadcast.
+	var (
+		mu                    sync.Mutex
+		wg                    sync.WaitGroup
+		done                  int
+		sent, blocked, failed int
+	)
+	lastProgress := time.Now()
+	title := "✅ Рассылка завершена\n"
+loop:
+	for _, userID := range b.Users {
+		select {
+		case <-ctx.Done():
+			title = "⚠️ Рассылка прервана остановкой бота\n"
+			break loop
+		case <-ticker.C:
+		}
+		wg.Add(1)
+		go func(userID int64) {
+			defer wg.Done()
+			_, err := send(tgbotapi.NewMessage(userID, b.Text))
+			if err != nil && !isBlockedError(err) {
+				slog.Warn("Ошибка рассылки", "job", "broadcast", "chat_id", userID, "err", err)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			done++
+			switch {
+			case err == nil:
+				sent++
+			case isBlockedError(err):
+				blocked++
+			default:
+				failed++
+			}
+		}(userID)
+
+		if progress.MessageID != 0 && time.Since(lastProgress) >= broadcastProgressEvery {
+			lastProgress = time.Now()
+			mu.Lock()
+			text := report(done, sent, blocked, failed)
+			mu.Unlock()
+			post(tgbotapi.NewEditMessageText(adminID, progress.MessageID, text))
+		}
+	}
+	wg.Wait()
+
+	post(tgbotapi.NewMessage(adminID, title+report(done, sent, blocked, failed)))
+}
+
+// isBlockedError reports whether Telegram refused a message because the
+// user blocked the bot or the chat no longer exists.
+func isBlockedError(err error) bool {
+	var tgErr *tgbotapi.Error
+	if !errors.As(err, &tgErr) {
+		return false
+	}
+	return tgErr.Code == 403 || strings.Contains(tgErr.Message, "chat not found")
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+	"unicode"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// channelConfig is a Telegram channel the bot posts weather to. ChatID is
+// either "@username" or the numeric id of the channel. Posts are
+// scheduled in the channel's own timezone.
+type channelConfig struct {
+	ID        int64
+	ChatID    string
+	City      string
+	Lat, Lon  float64
+	HasCoords bool
+	Timezone  string
+	Posts     []channelPost
+}
+
+// channelPost is one scheduled post of a channel. Schedule is "HH:MM" for
+// a daily post or "*:MM" for an hourly one; Template overrides the default
+// "channel_<type>" template.
+//
+// A "live" post is a single pinned message edited in place; its Schedule
+// is "*/N", the update interval in minutes, and MessageID is the message
+// being edited.
+type channelPost struct {
+	ID        int64
+	ChannelID int64
+	Type      string
+	Schedule  string
+	Template  string
+	MessageID int
+}
+
+// channelPostTypes lists the supported post types.
+var channelPostTypes = []string{"current", "tomorrow", "weekly", "chart", "live"}
+
+const (
+	channelTickInterval = 30 * time.Second
+	liveForecastHours   = 6
+	// channelMaxDelay is how late a scheduled post may still go out, e.g.
+	// after a slow run of the scheduler; older ones are skipped.
+	channelMaxDelay = 15 * time.Minute
+)
+
+func (ch channelConfig) place() place {
+	return place{City: ch.City, Lat: ch.Lat, Lon: ch.Lon, HasCoords: ch.HasCoords}
+}
+
+func (ch channelConfig) location() *time.Location {
+	loc, err := time.LoadLocation(ch.Timezone)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
+// parseSchedule parses "HH:MM" and "*:MM"; hour is -1 for hourly posts.
+func parseSchedule(s string) (hour, minute int, ok bool) {
+	h, m, found := strings.Cut(strings.TrimSpace(s), ":")
+	if !found {
+		return 0, 0, false
+	}
+	minute, err := strconv.Atoi(m)
+	if err != nil || minute < 0 || minute > 59 {
+		return 0, 0, false
+	}
+	if h == "*" {
+		return -1, minute, true
+	}
+	hour, err = strconv.Atoi(h)
+	if err != nil || hour < 0 || hour > 23 {
+		return 0, 0, false
+	}
+	return hour, minute, true
+}
+
+// parseLiveInterval parses the "*/N" schedule of a live post.
+func parseLiveInterval(s string) (time.Duration, bool) {
+	n, ok := strings.CutPrefix(strings.TrimSpace(s), "*/")
+	if !ok {
+		return 0, false
+	}
+	minutes, err := strconv.Atoi(n)
+	if err != nil || minutes < 1 || minutes > 60 {
+		return 0, false
+	}
+	return time.Duration(minutes) * time.Minute, true
+}
+
+// lastDue returns the latest time at or before local the post was due.
+func (p channelPost) lastDue(local time.Time) (time.Time, bool) {
+	hour, minute, ok := parseSchedule(p.Schedule)
+	if !ok {
+		return time.Time{}, false
+	}
+	y, m, d := local.Date()
+	if hour < 0 {
+		t := time.Date(y, m, d, local.Hour(), minute, 0, 0, local.Location())
+		if t.After(local) {
+			t = t.Add(-time.Hour)
+		}
+		return t, true
+	}
+	t := time.Date(y, m, d, hour, minute, 0, 0, local.Location())
+	if t.After(local) {
+		t = t.AddDate(0, 0, -1)
+	}
+	return t, true
+}
+
+func (p channelPost) templateName() string {
+	if p.Template != "" {
+		return p.Template
+	}
+	return "channel_" + p.Type
+}
+
+// seedDefaultChannel keeps older deployments working: when no channels are
+// stored yet, the configured default channel (CHANNEL_ID) is added with the
+// former hourly and morning posts.
+func seedDefaultChannel(db *DB, cfg ChannelsConfig) {
+	if cfg.Default == "" || len(ListChannels(db)) > 0 {
+		return
+	}
+	id, err := AddChannel(db, cfg.Default, cfg.City, cfg.Timezone)
+	if err != nil {
+		slog.Error("Ошибка создания канала по умолчанию", "chat_id", cfg.Default, "err", err)
+		return
+	}
+	AddChannelPost(db, id, "current", "*:00", "")
+	AddChannelPost(db, id, "weekly", "07:00", "")
+	if cfg.Charts {
+		AddChannelPost(db, id, "chart", "07:00", "")
+	}
+}
+
+// startChannelScheduler publishes the channel posts. Every post remembers
+// when it was last published, so a run that takes longer than a tick only
+// delays the posts that fell due meanwhile; posts due before the start are
+// not published again.
+func startChannelScheduler(ctx context.Context, db *DB) {
+	started := time.Now().Truncate(time.Minute)
+	lastRun := make(map[int64]time.Time)
+	liveUpdated := make(map[int64]time.Time)
+	runEvery(ctx, "channels", every(channelTickInterval), func(ctx context.Context) {
+		for _, ch := range ListChannels(db) {
+			local := time.Now().In(ch.location())
+			for _, post := range ch.Posts {
+				if post.Type == "live" {
+					interval, ok := parseLiveInterval(post.Schedule)
+					if !ok || time.Since(liveUpdated[post.ID]) < interval {
+						continue
+					}
+					liveUpdated[post.ID] = time.Now()
+					err := updateLiveMessage(ctx, db, ch, post)
+					countDelivery("channel", err)
+					if err != nil {
+						slog.Error("Ошибка обновления живого сообщения", "job", "channels", "chat_id", ch.ChatID, "post_id", post.ID, "err", err)
+					}
+					continue
+				}
+				due, ok := post.lastDue(local)
+				last, seen := lastRun[post.ID]
+				if !seen {
+					last = started.Add(-time.Nanosecond)
+				}
+				if !ok || !due.After(last) {
+					continue
+				}
+				lastRun[post.ID] = due
+				if late := time.Since(due); late > channelMaxDelay {
+					slog.Warn("Пост канала пропущен: опоздал", "job", "channels", "chat_id", ch.ChatID, "post_id", post.ID, "late", late.Round(time.Second))
+					continue
+				}
+				err := publishChannelPost(ctx, ch, post)
+				countDelivery("channel", err)
+				if err != nil {
+					slog.Error("Ошибка публикации в канал", "job", "channels", "chat_id", ch.ChatID, "post_id", post.ID, "post_type", post.Type, "err", err)
+				}
+			}
+		}
+	})
+}
+
+// publishChannelPost fetches the data for the post type, renders it and
+// sends it to the channel.
+func publishChannelPost(ctx context.Context, ch channelConfig, post channelPost) error {
+	pl := ch.place()
+	var data any
+
+	switch post.Type {
+	case "current":
+		current, err := fetchCurrent(ctx, pl, true)
+		if err != nil {
+			return err
+		}
+		data = channelCurrentView{City: ch.City, Current: current}
+	case "tomorrow":
+		tomorrow, err := tomorrowData(ctx, pl)
+		if err != nil {
+			return err
+		}
+		data = channelTomorrowView{City: ch.City, Tomorrow: tomorrow}
+	case "weekly":
+		weekly, err := weeklyData(ctx, pl)
+		if err != nil {
+			return err
+		}
+		data = channelWeeklyView{City: ch.City, Weekly: weekly}
+	case "chart":
+		png, err := forecastChartAt(ctx, pl, "week", "ru")
+		if err != nil {
+			return err
+		}
+		photo := tgbotapi.NewPhoto(0, tgbotapi.FileBytes{Name: "forecast.png", Bytes: png})
+		photo.ChannelUsername = ch.ChatID
+		_, err = send(photo)
+		return err
+	default:
+		return fmt.Errorf("неизвестный тип поста %q", post.Type)
+	}
+
+	msg, err := renderChannelTemplate(ch.ChatID, post.templateName(), data)
+	if err != nil {
+		return err
+	}
+	_, err = send(msg.channelMessage(ch.ChatID))
+	return err
+}
+
+// channelLiveView is the data of the "channel_live" template.
+type channelLiveView struct {
+	City    string
+	Current *currentView
+	Points  []hourlyPoint
+	Updated time.Time
+}
+
+// updateLiveMessage edits the pinned live message of the channel. When
+// there is no message yet, or it was deleted, a new one is sent and pinned
+// and its id stored so updates survive restarts.
+func updateLiveMessage(ctx context.Context, db *DB, ch channelConfig, post channelPost) error {
+	pl := ch.place()
+	current, err := fetchCurrent(ctx, pl, true)
+	if err != nil {
+		return err
+	}
+	data, err := provider.Forecast(ctx, pl)
+	if err != nil {
+		return err
+	}
+	view := channelLiveView{
+		City:    ch.City,
+		Current: current,
+		Points:  nextHours(data, time.Now(), liveForecastHours),
+		Updated: time.Now().In(ch.location()),
+	}
+	msg, err := renderChannelTemplate(ch.ChatID, post.templateName(), view)
+	if err != nil {
+		return err
+	}
+
+	if post.MessageID != 0 {
+		edit := tgbotapi.EditMessageTextConfig{
+			BaseEdit:  tgbotapi.BaseEdit{ChannelUsername: ch.ChatID, MessageID: post.MessageID},
+			Text:      msg.Text,
+			ParseMode: msg.ParseMode,
+		}
+		_, err := send(edit)
+		if err == nil || strings.Contains(err.Error(), "message is not modified") {
+			return nil
+		}
+		if !strings.Contains(err.Error(), "message to edit not found") {
+			return err
+		}
+	}
+
+	sent, err := send(msg.channelMessage(ch.ChatID))
+	if err != nil {
+		return err
+	}
+	SetChannelPostMessage(db, post.ID, sent.MessageID)
+	pin := tgbotapi.PinChatMessageConfig{
+		ChannelUsername:     ch.ChatID,
+		MessageID:           sent.MessageID,
+		DisableNotification: true,
+	}
+	if err := request(pin); err != nil {
+		slog.Warn("Не удалось закрепить сообщение", "chat_id", ch.ChatID, "message_id", sent.MessageID, "err", err)
+	}
+	return nil
+}
+
+func validPostType(t string) bool {
+	for _, pt := range channelPostTypes {
+		if pt == t {
+			return true
+		}
+	}
+	return false
+}
+
+func formatChannels(channels []channelConfig) string {
+	if len(channels) == 0 {
+		return "Каналы не настроены."
+	}
+	var b strings.Builder
+	for _, ch := range channels {
+		fmt.Fprintf(&b, "#%d %s — %s (%s)", ch.ID, ch.ChatID, ch.City, ch.Timezone)
+		if ch.HasCoords {
+			fmt.Fprintf(&b, ", %.4f %.4f", ch.Lat, ch.Lon)
+		}
+		b.WriteString("\n")
+		for _, p := range ch.Posts {
+			fmt.Fprintf(&b, "    пост #%d: %s в %s", p.ID, p.Type, p.Schedule)
+			if p.Template != "" {
+				fmt.Fprintf(&b, ", шаблон %s", p.Template)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
+
+const channelCommandsHelp = `Управление каналами:
+/channels — список каналов и постов
+/channel_add <канал> <часовой пояс> <город> — добавить канал, например /channel_add @simf_weather Europe/Simferopol Симферополь
+/channel_coords <id> <широта> <долгота> — задать координаты вместо города
+/channel_del <id> — удалить канал
+/post_add <id канала> <тип> <расписание> [шаблон] — тип: current, tomorrow, weekly, chart; расписание: 07:00 или *:00
+/post_add <id канала> live */15 — закреплённое сообщение, которое обновляется каждые 15 минут
+/post_del <id поста> — удалить пост`
+
+// handleChannelCommand runs the channel management commands. It reports
+// false for commands it doesn't know.
+func handleChannelCommand(db *DB, chatID int64, command string, args []string) bool {
+	reply := func(text string) {
+		post(tgbotapi.NewMessage(chatID, text))
+	}
+
+	switch command {
+	case "channels":
+		reply(formatChannels(ListChannels(db)) + "\n\n" + channelCommandsHelp)
+
+	case "channel_add":
+		if len(args) < 3 {
+			reply("Использование: /channel_add <канал> <часовой пояс> <город>")
+			return true
+		}
+		if !validChannelID(args[0]) {
+			reply("Канал задаётся как @имя или числовой id вида -100…")
+			return true
+		}
+		if _, err := time.LoadLocation(args[1]); err != nil {
+			reply("Неизвестный часовой пояс: " + args[1])
+			return true
+		}
+		id, err := AddChannel(db, args[0], strings.Join(args[2:], " "), args[1])
+		if err != nil {
+			reply("Не удалось добавить канал: " + err.Error())
+			return true
+		}
+		reply(fmt.Sprintf("Канал #%d добавлен. Добавьте посты командой /post_add %d <тип> <расписание>", id, id))
+
+	case "channel_coords":
+		if len(args) != 3 {
+			reply("Использование: /channel_coords <id> <широта> <долгота>")
+			return true
+		}
+		id, err1 := strconv.ParseInt(args[0], 10, 64)
+		lat, err2 := strconv.ParseFloat(args[1], 64)
+		lon, err3 := strconv.ParseFloat(args[2], 64)
+		if err1 != nil || err2 != nil || err3 != nil || lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+			reply("Некорректные id или координаты")
+			return true
+		}
+		if !SetChannelCoords(db, id, lat, lon) {
+			reply("Канал не найден")
+			return true
+		}
+		reply("Координаты канала сохранены")
+
+	case "channel_del":
+		id, err := strconv.ParseInt(strings.Join(args, ""), 10, 64)
+		if err != nil || !DeleteChannel(db, id) {
+			reply("Канал не найден")
+			return true
+		}
+		reply("Канал удалён")
+
+	case "post_add":
+		if len(args) < 3 || len(args) > 4 {
+			reply("Использование: /post_add <id канала> <тип> <расписание> [шаблон]")
+			return true
+		}
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		ch, found := findChannel(db, id)
+		if err != nil || !found {
+			reply("Канал не найден")
+			return true
+		}
+		if !validPostType(args[1]) {
+			reply("Тип поста должен быть одним из: " + strings.Join(channelPostTypes, ", "))
+			return true
+		}
+		if args[1] == "live" {
+			if _, ok := parseLiveInterval(args[2]); !ok {
+				reply("Для live укажите интервал обновления в минутах, например */15")
+				return true
+			}
+		} else if _, _, ok := parseSchedule(args[2]); !ok {
+			reply("Расписание должно быть в формате 07:00 или *:00")
+			return true
+		}
+		tmpl := ""
+		if len(args) == 4 {
+			tmpl = args[3]
+			if !channelTemplateExists(ch.ChatID, tmpl) {
+				reply("Шаблон не найден: " + tmpl)
+				return true
+			}
+		}
+		postID := AddChannelPost(db, id, args[1], args[2], tmpl)
+		reply(fmt.Sprintf("Пост #%d добавлен", postID))
+
+	case "post_del":
+		id, err := strconv.ParseInt(strings.Join(args, ""), 10, 64)
+		if err != nil || !DeleteChannelPost(db, id) {
+			reply("Пост не найден")
+			return true
+		}
+		reply("Пост удалён")
+
+	default:
+		return false
+	}
+	return true
+}
+
+func findChannel(db *DB, id int64) (channelConfig, bool) {
+	for _, ch := range ListChannels(db) {
+		if ch.ID == id {
+			return ch, true
+		}
+	}
+	return channelConfig{}, false
+}
+
+// validChannelID accepts the forms Telegram takes for a channel: its
+// @username or its numeric id, which starts with -100.
+func validChannelID(s string) bool {
+	if name, ok := strings.CutPrefix(s, "@"); ok {
+		if len(name) < 5 || len(name) > 32 || !unicode.IsLetter(rune(name[0])) {
+			return false
+		}
+		for _, r := range name {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+				return false
+			}
+		}
+		return true
+	}
+	digits, ok := strings.CutPrefix(s, "-100")
+	if !ok || digits == "" {
+		return false
+	}
+	_, err := strconv.ParseUint(digits, 10, 64)
+	return err == nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	chartWidth  = 960
+	chartHeight = 480
+
+	chartMarginLeft   = 56
+	chartMarginRight  = 56
+	chartMarginTop    = 48
+	chartMarginBottom = 44
+
+	// chartMinPrecipScale keeps light drizzle from filling the whole
+	// precipitation axis.
+	chartMinPrecipScale = 5.0
+)
+
+var (
+	chartBackground = color.RGBA{255, 255, 255, 255}
+	chartGrid       = color.RGBA{226, 230, 236, 255}
+	chartAxisText   = color.RGBA{90, 98, 110, 255}
+	chartTitleText  = color.RGBA{30, 34, 40, 255}
+	chartCurve      = color.RGBA{226, 87, 60, 255}
+	chartBand       = color.RGBA{250, 214, 190, 255}
+	chartPrecip     = color.RGBA{96, 160, 230, 255}
+)
+
+// chartPoint is one sample of the temperature curve; Precip is the amount
+// for the step that starts at Time, in mm.
+type chartPoint struct {
+	Time   time.Time
+	Temp   float64
+	Precip float64
+}
+
+// chartBandSpan is the min/max temperature range over [From, To).
+type chartBandSpan struct {
+	From, To time.Time
+	Min, Max float64
+}
+
+type chartLabel struct {
+	Time time.Time
+	Text string
+}
+
+// chartData is everything the renderer draws. Building it is separate from
+// rendering so the same renderer serves the daily and weekly charts.
+type chartData struct {
+	Title       string
+	PrecipUnit  string
+	Points      []chartPoint
+	Bands       []chartBandSpan
+	XLabels     []chartLabel
+	Separators  []time.Time
+	PrecipWidth time.Duration
+}
+
+// chartLabels holds the words used on charts per user language.
+type chartLabels struct {
+	Weekdays   []string
+	Day        string
+	Week       string
+	PrecipUnit string
+}
+
+var chartLabelSets = map[string]chartLabels{
+	"ru": {
+		Weekdays:   []string{"Вс", "Пн", "Вт", "Ср", "Чт", "Пт", "Сб"},
+		Day:        "Прогноз на сутки",
+		Week:       "Прогноз на неделю",
+		PrecipUnit: "мм",
+	},
+	"en": {
+		Weekdays:   []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"},
+		Day:        "24-hour forecast",
+		Week:       "Weekly forecast",
+		PrecipUnit: "mm",
+	},
+}
+
+// labelsFor picks the chart language from a Telegram language code. The
+// bot speaks Russian, so unknown languages fall back to it.
+func labelsFor(lang string) chartLabels {
+	if strings.HasPrefix(lang, "en") {
+		return chartLabelSets["en"]
+	}
+	return chartLabelSets["ru"]
+}
+
+var (
+	chartFontOnce sync.Once
+	chartFont     *sfnt.Font
+	chartFontErr  error
+)
+
+// loadChartFaces returns the axis and title faces for one render. The
+// parsed font is shared, but a Face is not safe for concurrent use, so
+// every render gets its own.
+func loadChartFaces() (font.Face, font.Face, error) {
+	chartFontOnce.Do(func() {
+		chartFont, chartFontErr = opentype.Parse(goregular.TTF)
+	})
+	if chartFontErr != nil {
+		return nil, nil, chartFontErr
+	}
+	face, err := opentype.NewFace(chartFont, &opentype.FaceOptions{Size: 13, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, nil, err
+	}
+	title, err := opentype.NewFace(chartFont, &opentype.FaceOptions{Size: 18, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, nil, err
+	}
+	return face, title, nil
+}
+
+// dailyChartData covers the next 24 hours of 3-hour entries with hour
+// labels in the city's local time.
+func dailyChartData(data *forecastResponse, now time.Time, lang string) chartData {
+	labels := labelsFor(lang)
+	loc := data.location()
+	c := chartData{
+		Title:       fmt.Sprintf("%s — %s", labels.Day, data.City.Name),
+		PrecipUnit:  labels.PrecipUnit,
+		PrecipWidth: forecastStep,
+	}
+
+	end := now.Add(24 * time.Hour)
+	for _, e := range data.List {
+		t := time.Unix(e.Dt, 0).In(loc)
+		if t.Add(forecastStep).Before(now) || t.After(end) {
+			continue
+		}
+		c.Points = append(c.Points, chartPoint{Time: t, Temp: e.Main.Temp, Precip: e.Rain.Volume + e.Snow.Volume})
+		c.XLabels = append(c.XLabels, chartLabel{Time: t, Text: t.Format("15:04")})
+		if t.Hour() == 0 {
+			c.Separators = append(c.Separators, t)
+		}
+	}
+	return c
+}
+
+// weeklyChartData covers the whole forecast with a min/max band per day and
+// day labels in the user's language.
+func weeklyChartData(data *forecastResponse, lang string) chartData {
+	labels := labelsFor(lang)
+	loc := data.location()
+	c := chartData{
+		Title:       fmt.Sprintf("%s — %s", labels.Week, data.City.Name),
+		PrecipUnit:  labels.PrecipUnit,
+		PrecipWidth: forecastStep,
+	}
+
+	for _, e := range data.List {
+		t := time.Unix(e.Dt, 0).In(loc)
+		c.Points = append(c.Points, chartPoint{Time: t, Temp: e.Main.Temp, Precip: e.Rain.Volume + e.Snow.Volume})
+	}
+	for i, ds := range summarizeDays(data) {
+		next := ds.Date.AddDate(0, 0, 1)
+		c.Bands = append(c.Bands, chartBandSpan{From: ds.Date, To: next, Min: ds.Min, Max: ds.Max})
+		c.XLabels = append(c.XLabels, chartLabel{
+			Time: ds.Date.Add(12 * time.Hour),
+			Text: fmt.Sprintf("%s %d", labels.Weekdays[ds.Date.Weekday()], ds.Date.Day()),
+		})
+		if i > 0 {
+			c.Separators = append(c.Separators, ds.Date)
+		}
+	}
+	return c
+}
+
+// renderChart draws the chart as a PNG image.
+func renderChart(c chartData) ([]byte, error) {
+	if len(c.Points) < 2 {
+		return nil, fmt.Errorf("недостаточно данных для графика")
+	}
+	face, titleFace, err := loadChartFaces()
+	if err != nil {
+		return nil, err
+	}
+	defer face.Close()
+	defer titleFace.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, chartWidth, chartHeight))
+	draw.Draw(img, img.Bounds(), &image.Uniform{chartBackground}, image.Point{}, draw.Src)
+
+	plot := image.Rect(chartMarginLeft, chartMarginTop, chartWidth-chartMarginRight, chartHeight-chartMarginBottom)
+	start, end := c.Points[0].Time, c.Points[len(c.Points)-1].Time
+	if c.PrecipWidth > 0 {
+		end = end.Add(c.PrecipWidth)
+	}
+	x := func(t time.Time) int {
+		k := float64(t.Sub(start)) / float64(end.Sub(start))
+		return plot.Min.X + int(math.Round(k*float64(plot.Dx())))
+	}
+
+	tMin, tMax := c.Points[0].Temp, c.Points[0].Temp
+	pMax := chartMinPrecipScale
+	for _, p := range c.Points {
+		tMin = math.Min(tMin, p.Temp)
+		tMax = math.Max(tMax, p.Temp)
+		pMax = math.Max(pMax, p.Precip)
+	}
+	for _, b := range c.Bands {
+		tMin = math.Min(tMin, b.Min)
+		tMax = math.Max(tMax, b.Max)
+	}
+	step := chartTempStep(tMax - tMin)
+	tMin = math.Floor(tMin/step)*step - step
+	tMax = math.Ceil(tMax/step)*step + step
+	y := func(temp float64) int {
+		k := (temp - tMin) / (tMax - tMin)
+		return plot.Max.Y - int(math.Round(k*float64(plot.Dy())))
+	}
+	yPrecip := func(mm float64) int {
+		return plot.Max.Y - int(math.Round(mm/pMax*float64(plot.Dy())/2))
+	}
+
+	for _, b := range c.Bands {
+		from, to := b.From, b.To
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if !from.Before(to) {
+			continue
+		}
+		fillRect(img, image.Rect(x(from), y(b.Max), x(to), y(b.Min)), chartBand)
+	}
+
+	for v := tMin; v <= tMax+step/2; v += step {
+		fillRect(img, image.Rect(plot.Min.X, y(v), plot.Max.X, y(v)+1), chartGrid)
+		label := fmt.Sprintf("%.0f°", v)
+		drawText(img, face, label, plot.Min.X-8-textWidth(face, label), y(v)+4, chartAxisText)
+	}
+	for _, mm := range []float64{0, pMax / 2, pMax} {
+		drawText(img, face, fmt.Sprintf("%g %s", math.Round(mm*10)/10, c.PrecipUnit), plot.Max.X+8, yPrecip(mm)+4, chartPrecip)
+	}
+	for _, t := range c.Separators {
+		fillRect(img, image.Rect(x(t), plot.Min.Y, x(t)+1, plot.Max.Y), chartGrid)
+	}
+
+	for _, p := range c.Points {
+		if p.Precip <= 0 {
+			continue
+		}
+		x0, x1 := x(p.Time), x(p.Time.Add(c.PrecipWidth))
+		pad := (x1 - x0) / 8
+		fillRect(img, image.Rect(x0+pad, yPrecip(p.Precip), x1-pad, plot.Max.Y), chartPrecip)
+	}
+
+	for i := 1; i < len(c.Points); i++ {
+		a, b := c.Points[i-1], c.Points[i]
+		drawLine(img, x(a.Time), y(a.Temp), x(b.Time), y(b.Temp), chartCurve)
+	}
+
+	fillRect(img, image.Rect(plot.Min.X, plot.Max.Y, plot.Max.X, plot.Max.Y+1), chartAxisText)
+	for _, l := range c.XLabels {
+		lx := x(l.Time)
+		if lx < plot.Min.X || lx > plot.Max.X {
+			continue
+		}
+		drawText(img, face, l.Text, lx-textWidth(face, l.Text)/2, plot.Max.Y+20, chartAxisText)
+	}
+	drawText(img, titleFace, c.Title, chartMarginLeft, chartMarginTop-18, chartTitleText)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// chartTempStep picks a grid step that gives at most about eight lines.
+func chartTempStep(span float64) float64 {
+	for _, s := range []float64{1, 2, 5, 10} {
+		if span/s <= 8 {
+			return s
+		}
+	}
+	return 20
+}
+
+func fillRect(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	draw.Draw(img, r.Canon(), &image.Uniform{c}, image.Point{}, draw.Over)
+}
+
+// drawLine draws a 3px wide line by stamping squares along the segment.
+func drawLine(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	steps := int(math.Max(math.Abs(float64(x1-x0)), math.Abs(float64(y1-y0))))
+	if steps == 0 {
+		steps = 1
+	}
+	for i := 0; i <= steps; i++ {
+		px := x0 + (x1-x0)*i/steps
+		py := y0 + (y1-y0)*i/steps
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				img.SetRGBA(px+dx, py+dy, c)
+			}
+		}
+	}
+}
+
+func drawText(img *image.RGBA, face font.Face, text string, x, y int, c color.RGBA) {
+	d := &font.Drawer{
+		Dst:  img,
+		Src:  &image.Uniform{c},
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	d.DrawString(text)
+}
+
+func textWidth(face font.Face, text string) int {
+	return font.MeasureString(face, text).Round()
+}
+
+// getForecastChart renders the "day" or "week" chart for the city.
+func getForecastChart(ctx context.Context, city, kind, lang string) ([]byte, error) {
+	return forecastChartAt(ctx, cityPlace(city), kind, lang)
+}
+
+func forecastChartAt(ctx context.Context, p place, kind, lang string) ([]byte, error) {
+	data, err := provider.Forecast(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if kind == "day" {
+		return renderChart(dailyChartData(data, time.Now(), lang))
+	}
+	return renderChart(weeklyChartData(data, lang))
+}
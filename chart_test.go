@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testForecast is five days of 3-hour entries for a city at UTC+3 with a
+// daily temperature wave and some rain in the afternoons.
+func testForecast() *forecastResponse {
+	data := &forecastResponse{}
+	data.City.Name = "Симферополь"
+	data.City.Timezone = 3 * 3600
+	start := time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 40; i++ {
+		var e forecastEntry
+		t := start.Add(time.Duration(i) * forecastStep)
+		e.Dt = t.Unix()
+		e.Main.Temp = 15 + 6*math.Sin(float64(i)*math.Pi/4) + float64(i)/10
+		if i%8 == 4 || i%8 == 5 {
+			e.Rain.Volume = float64(i%5) + 0.4
+		}
+		data.List = append(data.List, e)
+	}
+	return data
+}
+
+func TestRenderChartGolden(t *testing.T) {
+	data := testForecast()
+	now := time.Unix(data.List[2].Dt, 0)
+	for _, tc := range []struct {
+		name string
+		c    chartData
+	}{
+		{"day_ru", dailyChartData(data, now, "ru")},
+		{"day_en", dailyChartData(data, now, "en")},
+		{"week_ru", weeklyChartData(data, "ru")},
+		{"week_en", weeklyChartData(data, "en")},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := renderChart(tc.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "chart_"+tc.name+".png")
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v (run go test -update to create it)", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s differs from the rendered chart; run go test -update and review the images", golden)
+			}
+		})
+	}
+}
+
+// TestRenderChartConcurrent renders from several goroutines, as the update
+// loop and the channel scheduler do; run it with -race.
+func TestRenderChartConcurrent(t *testing.T) {
+	c := weeklyChartData(testForecast(), "ru")
+	want, err := renderChart(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 5; j++ {
+				got, err := renderChart(c)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !bytes.Equal(got, want) {
+					t.Error("concurrent render differs from a single one")
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
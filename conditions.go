@@ -0,0 +1,137 @@
+package main
+
+import "strings"
+
+// condition is the provider-independent weather category. Backends map
+// their own codes to it so output and alerts don't depend on one API.
+type condition int
+
+const (
+	conditionUnknown condition = iota
+	conditionClear
+	conditionPartlyCloudy
+	conditionMostlyCloudy
+	conditionOvercast
+	conditionDrizzle
+	conditionRain
+	conditionThunderstorm
+	conditionSnow
+	conditionSleet
+	conditionFog
+	conditionDust
+	conditionSquall
+	conditionTornado
+)
+
+var conditionNames = map[condition]string{
+	conditionUnknown:      "нет данных",
+	conditionClear:        "ясно",
+	conditionPartlyCloudy: "переменная облачность",
+	conditionMostlyCloudy: "облачно с прояснениями",
+	conditionOvercast:     "пасмурно",
+	conditionDrizzle:      "морось",
+	conditionRain:         "дождь",
+	conditionThunderstorm: "гроза",
+	conditionSnow:         "снег",
+	conditionSleet:        "мокрый снег",
+	conditionFog:          "туман",
+	conditionDust:         "пыль",
+	conditionSquall:       "шквал",
+	conditionTornado:      "смерч",
+}
+
+func (c condition) String() string {
+	return conditionNames[c]
+}
+
+// Emoji returns the symbol for the condition; day selects the sun or moon
+// variant where there is one.
+func (c condition) Emoji(day bool) string {
+	switch c {
+	case conditionClear:
+		if day {
+			return "☀️"
+		}
+		return "🌙"
+	case conditionPartlyCloudy:
+		if day {
+			return "🌤"
+		}
+		return "☁️"
+	case conditionMostlyCloudy:
+		if day {
+			return "⛅"
+		}
+		return "☁️"
+	case conditionOvercast:
+		return "☁️"
+	case conditionDrizzle:
+		if day {
+			return "🌦"
+		}
+		return "🌧"
+	case conditionRain:
+		return "🌧"
+	case conditionThunderstorm:
+		return "⛈"
+	case conditionSnow:
+		return "❄️"
+	case conditionSleet:
+		return "🌨"
+	case conditionFog, conditionDust:
+		return "🌫"
+	case conditionSquall:
+		return "💨"
+	case conditionTornado:
+		return "🌪"
+	}
+	return "🌡"
+}
+
+// Precipitating reports whether the condition brings rain or snow.
+func (c condition) Precipitating() bool {
+	switch c {
+	case conditionDrizzle, conditionRain, conditionThunderstorm, conditionSnow, conditionSleet:
+		return true
+	}
+	return false
+}
+
+// owmCondition maps an OpenWeatherMap condition id and icon code
+// (e.g. 500 and "10d") to a condition and whether it is daytime.
+// See https://openweathermap.org/weather-conditions.
+func owmCondition(id int, icon string) (condition, bool) {
+	day := !strings.HasSuffix(icon, "n")
+
+	switch {
+	case id >= 200 && id < 300:
+		return conditionThunderstorm, day
+	case id >= 300 && id < 400:
+		return conditionDrizzle, day
+	case id == 511:
+		return conditionSleet, day
+	case id >= 500 && id < 600:
+		return conditionRain, day
+	case id >= 611 && id <= 616:
+		return conditionSleet, day
+	case id >= 600 && id < 700:
+		return conditionSnow, day
+	case id == 731 || id == 751 || id == 761 || id == 762:
+		return conditionDust, day
+	case id == 771:
+		return conditionSquall, day
+	case id == 781:
+		return conditionTornado, day
+	case id >= 700 && id < 800:
+		return conditionFog, day
+	case id == 800:
+		return conditionClear, day
+	case id == 801 || id == 802:
+		return conditionPartlyCloudy, day
+	case id == 803:
+		return conditionMostlyCloudy, day
+	case id == 804:
+		return conditionOvercast, day
+	}
+	return conditionUnknown, day
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestOWMCondition(t *testing.T) {
+	for _, tc := range []struct {
+		id   int
+		icon string
+		want condition
+		day  bool
+	}{
+		{200, "11d", conditionThunderstorm, true},
+		{232, "11n", conditionThunderstorm, false},
+		{300, "09d", conditionDrizzle, true},
+		{500, "10d", conditionRain, true},
+		{511, "13d", conditionSleet, true},
+		{531, "09n", conditionRain, false},
+		{600, "13d", conditionSnow, true},
+		{611, "13d", conditionSleet, true},
+		{616, "13n", conditionSleet, false},
+		{622, "13d", conditionSnow, true},
+		{701, "50d", conditionFog, true},
+		{741, "50n", conditionFog, false},
+		{731, "50d", conditionDust, true},
+		{762, "50d", conditionDust, true},
+		{771, "50d", conditionSquall, true},
+		{781, "50d", conditionTornado, true},
+		{800, "01d", conditionClear, true},
+		{800, "01n", conditionClear, false},
+		{801, "02d", conditionPartlyCloudy, true},
+		{802, "03d", conditionPartlyCloudy, true},
+		{803, "04d", conditionMostlyCloudy, true},
+		{804, "04n", conditionOvercast, false},
+		{900, "", conditionUnknown, true},
+	} {
+		got, day := owmCondition(tc.id, tc.icon)
+		if got != tc.want || day != tc.day {
+			t.Errorf("owmCondition(%d, %q) = %v, %v; want %v, %v", tc.id, tc.icon, got, day, tc.want, tc.day)
+		}
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the bot configuration. It is read from a YAML or TOML file
+// (see config.example.yaml), then environment variables override single
+// settings, so a deployment can keep secrets out of the file.
+type Config struct {
+	Telegram  TelegramConfig  `yaml:"telegram" toml:"telegram"`
+	Database  DatabaseConfig  `yaml:"database" toml:"database"`
+	Weather   WeatherConfig   `yaml:"weather" toml:"weather"`
+	Cache     CacheConfig     `yaml:"cache" toml:"cache"`
+	Schedule  ScheduleConfig  `yaml:"schedule" toml:"schedule"`
+	Channels  ChannelsConfig  `yaml:"channels" toml:"channels"`
+	Templates TemplatesConfig `yaml:"templates" toml:"templates"`
+	Webhook   WebhookConfig   `yaml:"webhook" toml:"webhook"`
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Log       LogConfig       `yaml:"log" toml:"log"`
+
+	// file is where the config was read from, for reloading.
+	file     string
+	required bool
+}
+
+type TelegramConfig struct {
+	Token    string  `yaml:"token" toml:"token"`
+	AdminIDs []int64 `yaml:"admin_ids" toml:"admin_ids"`
+}
+
+type DatabaseConfig struct {
+	Path string `yaml:"path" toml:"path"`
+}
+
+type WeatherConfig struct {
+	Provider string `yaml:"provider" toml:"provider"`
+	APIKey   string `yaml:"api_key" toml:"api_key"`
+	// APIKeys are more keys to rotate through when one key's limits are
+	// not enough.
+	APIKeys []string `yaml:"api_keys" toml:"api_keys"`
+	// OneCall enables the One Call 3.0 API (minutely precipitation, UV),
+	// which needs a separate subscription.
+	OneCall bool `yaml:"onecall" toml:"onecall"`
+	// Timeout bounds one API call, reading the answer included.
+	Timeout time.Duration `yaml:"timeout" toml:"timeout"`
+	Quota   QuotaConfig   `yaml:"quota" toml:"quota"`
+}
+
+// keys returns every configured API key, api_key first.
+func (c WeatherConfig) keys() []string {
+	var keys []string
+	for _, k := range append([]string{c.APIKey}, c.APIKeys...) {
+		if k != "" {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// QuotaConfig is the call limit of one API key; 0 means no limit. The
+// free plan allows 60 calls a minute and a million a month, which is not
+// a daily limit, and the One Call subscription 1000 calls a day on top.
+type QuotaConfig struct {
+	PerMinute     int `yaml:"per_minute" toml:"per_minute"`
+	PerDay        int `yaml:"per_day" toml:"per_day"`
+	OneCallPerDay int `yaml:"onecall_per_day" toml:"onecall_per_day"`
+}
+
+type CacheConfig struct {
+	Current    time.Duration `yaml:"current" toml:"current"`
+	Forecast   time.Duration `yaml:"forecast" toml:"forecast"`
+	Minutely   time.Duration `yaml:"minutely" toml:"minutely"`
+	UV         time.Duration `yaml:"uv" toml:"uv"`
+	AirQuality time.Duration `yaml:"air_quality" toml:"air_quality"`
+}
+
+type ScheduleConfig struct {
+	MorningHour     int           `yaml:"morning_hour" toml:"morning_hour"`
+	EveningHour     int           `yaml:"evening_hour" toml:"evening_hour"`
+	AlertInterval   time.Duration `yaml:"alert_interval" toml:"alert_interval"`
+	NowcastInterval time.Duration `yaml:"nowcast_interval" toml:"nowcast_interval"`
+	// PrefetchLead is how long before each hourly slot the weather of the
+	// subscribers due in it is fetched into the cache.
+	PrefetchLead time.Duration `yaml:"prefetch_lead" toml:"prefetch_lead"`
+}
+
+// The schedulers read the schedule on every run through currentSchedule,
+// so /reload can change it while they are running.
+var (
+	scheduleMu   sync.RWMutex
+	liveSchedule ScheduleConfig
+)
+
+func currentSchedule() ScheduleConfig {
+	scheduleMu.RLock()
+	defer scheduleMu.RUnlock()
+	return liveSchedule
+}
+
+func setSchedule(s ScheduleConfig) {
+	scheduleMu.Lock()
+	liveSchedule = s
+	scheduleMu.Unlock()
+}
+
+// ChannelsConfig describes the channel created on first start when the
+// database has none yet; later channels are managed with bot commands.
+type ChannelsConfig struct {
+	Default  string `yaml:"default" toml:"default"`
+	City     string `yaml:"city" toml:"city"`
+	Timezone string `yaml:"timezone" toml:"timezone"`
+	Charts   bool   `yaml:"charts" toml:"charts"`
+}
+
+type TemplatesConfig struct {
+	Dir string `yaml:"dir" toml:"dir"`
+}
+
+type WebhookConfig struct {
+	URL    string `yaml:"url" toml:"url"`
+	Listen string `yaml:"listen" toml:"listen"`
+	Secret string `yaml:"secret" toml:"secret"`
+	Cert   string `yaml:"cert" toml:"cert"`
+	Key    string `yaml:"key" toml:"key"`
+}
+
+// ServerConfig is the HTTP server for metrics and health checks; it is
+// off when Listen is empty.
+type ServerConfig struct {
+	Listen string `yaml:"listen" toml:"listen"`
+}
+
+// LogConfig sets the log format (text or json) and the lowest level
+// written (debug, info, warn or error).
+type LogConfig struct {
+	Format string `yaml:"format" toml:"format"`
+	Level  string `yaml:"level" toml:"level"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Database: DatabaseConfig{Path: "weather.db"},
+		Weather: WeatherConfig{
+			Provider: "openweathermap",
+			Timeout:  10 * time.Second,
+			Quota:    QuotaConfig{PerMinute: 60, OneCallPerDay: 1000},
+		},
+		Cache: CacheConfig{
+			Current:    10 * time.Minute,
+			Forecast:   10 * time.Minute,
+			Minutely:   5 * time.Minute,
+			UV:         30 * time.Minute,
+			AirQuality: 30 * time.Minute,
+		},
+		Schedule: ScheduleConfig{
+			MorningHour:     8,
+			EveningHour:     20,
+			AlertInterval:   30 * time.Minute,
+			NowcastInterval: 10 * time.Minute,
+			PrefetchLead:    5 * time.Minute,
+		},
+		Channels: ChannelsConfig{City: "Симферополь", Timezone: "Europe/Simferopol"},
+		Webhook:  WebhookConfig{Listen: ":8443"},
+		Log:      LogConfig{Format: "text", Level: "info"},
+	}
+}
+
+// configFile picks the config file: the -config flag, then CONFIG_FILE,
+// then config.yaml if present. Only an explicitly named file is required.
+func configFile(flagValue string) (path string, required bool) {
+	if flagValue != "" {
+		return flagValue, true
+	}
+	if env := os.Getenv("CONFIG_FILE"); env != "" {
+		return env, true
+	}
+	return "config.yaml", false
+}
+
+// loadConfig reads the file at path over the defaults, applies the
+// environment and validates the result. A missing file is fine when
+// required is false, so the bot can be configured by environment alone.
+func loadConfig(path string, required bool) (*Config, error) {
+	cfg := defaultConfig()
+	cfg.file, cfg.required = path, required
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := decodeConfig(path, data, cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	case errors.Is(err, os.ErrNotExist) && !required:
+	default:
+		return nil, err
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func decodeConfig(path string, data []byte, cfg *Config) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		return nil
+	case ".toml":
+		md, err := toml.Decode(string(data), cfg)
+		if err != nil {
+			return err
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("неизвестный параметр %s", undecoded[0])
+		}
+		return nil
+	}
+	return fmt.Errorf("неизвестный формат файла, нужен .yaml или .toml")
+}
+
+// applyEnv overrides settings with the environment variables the bot has
+// always used.
+func (c *Config) applyEnv() error {
+	str := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	// Flags used to be "on" when set to anything non-empty; "false" and
+	// "0" now turn them off.
+	boolean := func(name string, dst *bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(v)
+			*dst = v != "" && (err != nil || b)
+		}
+	}
+
+	str("TELEGRAM_TOKEN", &c.Telegram.Token)
+	str("DB_PATH", &c.Database.Path)
+	str("WEATHER_PROVIDER", &c.Weather.Provider)
+	str("OPENWEATHER_TOKEN", &c.Weather.APIKey)
+	str("CHANNEL_ID", &c.Channels.Default)
+	str("TEMPLATES_DIR", &c.Templates.Dir)
+	str("WEBHOOK_URL", &c.Webhook.URL)
+	str("WEBHOOK_LISTEN", &c.Webhook.Listen)
+	str("WEBHOOK_SECRET", &c.Webhook.Secret)
+	str("WEBHOOK_CERT", &c.Webhook.Cert)
+	str("WEBHOOK_KEY", &c.Webhook.Key)
+	str("HTTP_LISTEN", &c.Server.Listen)
+	str("LOG_FORMAT", &c.Log.Format)
+	str("LOG_LEVEL", &c.Log.Level)
+	boolean("OPENWEATHER_ONECALL", &c.Weather.OneCall)
+	boolean("CHANNEL_CHARTS", &c.Channels.Charts)
+
+	if v, ok := os.LookupEnv("OPENWEATHER_TOKENS"); ok {
+		c.Weather.APIKeys = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				c.Weather.APIKeys = append(c.Weather.APIKeys, s)
+			}
+		}
+	}
+
+	if v, ok := os.LookupEnv("ADMIN_IDS"); ok {
+		c.Telegram.AdminIDs = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s == "" {
+				continue
+			}
+			id, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return fmt.Errorf("ADMIN_IDS: %q не является id пользователя", s)
+			}
+			c.Telegram.AdminIDs = append(c.Telegram.AdminIDs, id)
+		}
+	}
+	return nil
+}
+
+// validSecretToken is the format Telegram accepts for secret_token.
+var validSecretToken = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// validate reports every problem at once, so a broken deployment is fixed
+// in one go.
+func (c *Config) validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Telegram.Token != "", "не задан токен бота (telegram.token или TELEGRAM_TOKEN)")
+	check(c.Database.Path != "", "не задан путь к базе (database.path)")
+	check(c.Weather.Provider == "openweathermap", "неизвестный провайдер погоды %q, поддерживается openweathermap", c.Weather.Provider)
+	check(len(c.Weather.keys()) > 0, "не задан ключ API погоды (weather.api_key или OPENWEATHER_TOKEN)")
+	check(c.Weather.Quota.PerMinute >= 0 && c.Weather.Quota.PerDay >= 0 && c.Weather.Quota.OneCallPerDay >= 0,
+		"лимиты weather.quota не могут быть отрицательными")
+
+	check(c.Weather.Timeout > 0, "weather.timeout должен быть больше нуля")
+
+	check(c.Cache.Current > 0, "cache.current должен быть больше нуля")
+	check(c.Cache.Forecast > 0, "cache.forecast должен быть больше нуля")
+	check(c.Cache.Minutely > 0, "cache.minutely должен быть больше нуля")
+	check(c.Cache.UV > 0, "cache.uv должен быть больше нуля")
+	check(c.Cache.AirQuality > 0, "cache.air_quality должен быть больше нуля")
+
+	check(c.Schedule.MorningHour >= 0 && c.Schedule.MorningHour <= 23, "schedule.morning_hour должен быть от 0 до 23")
+	check(c.Schedule.EveningHour >= 0 && c.Schedule.EveningHour <= 23, "schedule.evening_hour должен быть от 0 до 23")
+	check(c.Schedule.AlertInterval >= time.Minute, "schedule.alert_interval должен быть не меньше минуты")
+	check(c.Schedule.NowcastInterval >= time.Minute, "schedule.nowcast_interval должен быть не меньше минуты")
+	check(c.Schedule.PrefetchLead >= 0 && c.Schedule.PrefetchLead < c.Cache.Current && c.Schedule.PrefetchLead < c.Cache.Forecast,
+		"schedule.prefetch_lead должен быть меньше cache.current и cache.forecast, иначе прогретые данные устареют к отправке")
+
+	if c.Channels.Default != "" {
+		check(c.Channels.City != "", "не задан город канала (channels.city)")
+		_, err := time.LoadLocation(c.Channels.Timezone)
+		check(err == nil, "неизвестный часовой пояс канала %q", c.Channels.Timezone)
+	}
+
+	if c.Webhook.URL != "" {
+		u, err := url.Parse(c.Webhook.URL)
+		check(err == nil && u.Scheme == "https" && u.Host != "", "webhook.url должен быть https-адресом")
+		check(c.Webhook.Listen != "", "не задан адрес сервера вебхука (webhook.listen)")
+		check(c.Webhook.Key == "" || c.Webhook.Cert != "", "webhook.key задан без webhook.cert")
+		check(c.Webhook.Secret == "" || validSecretToken.MatchString(c.Webhook.Secret),
+			"webhook.secret должен состоять из 1–256 символов A-Z, a-z, 0-9, _ и -")
+	}
+
+	check(c.Log.Format == "text" || c.Log.Format == "json", "log.format должен быть text или json, а не %q", c.Log.Format)
+	_, err := parseLogLevel(c.Log.Level)
+	check(err == nil, "log.level должен быть debug, info, warn или error, а не %q", c.Log.Level)
+
+	if len(errs) > 0 {
+		return errors.New("ошибки конфигурации:\n- " + strings.Join(errs, "\n- "))
+	}
+	return nil
+}
+
+func (c *Config) isAdmin(userID int64) bool {
+	for _, id := range c.Telegram.AdminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// restartOnly returns the settings that differ in next but are only read
+// at startup: the connections to Telegram, the database and the HTTP
+// servers, the weather client and the log format. /reload refuses such
+// changes instead of ignoring them.
+func (c *Config) restartOnly(next *Config) []string {
+	var changed []string
+	diff := func(differs bool, name string) {
+		if differs {
+			changed = append(changed, name)
+		}
+	}
+	diff(c.Telegram.Token != next.Telegram.Token, "telegram.token")
+	diff(c.Database.Path != next.Database.Path, "database.path")
+	diff(c.Weather.Provider != next.Weather.Provider, "weather.provider")
+	diff(c.Weather.OneCall != next.Weather.OneCall, "weather.onecall")
+	diff(c.Weather.Timeout != next.Weather.Timeout, "weather.timeout")
+	diff(c.Webhook != next.Webhook, "webhook")
+	diff(c.Server != next.Server, "server.listen")
+	diff(c.Log.Format != next.Log.Format, "log.format")
+	return changed
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyEnvBool(t *testing.T) {
+	for _, tc := range []struct {
+		value string
+		want  bool
+	}{
+		{"true", true},
+		{"1", true},
+		{"yes", true}, // set to anything used to mean on
+		{"false", false},
+		{"0", false},
+		{"", false},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			t.Setenv("OPENWEATHER_ONECALL", tc.value)
+			t.Setenv("CHANNEL_CHARTS", tc.value)
+			cfg := defaultConfig()
+			cfg.Weather.OneCall = !tc.want
+			cfg.Channels.Charts = !tc.want
+			if err := cfg.applyEnv(); err != nil {
+				t.Fatal(err)
+			}
+			if cfg.Weather.OneCall != tc.want || cfg.Channels.Charts != tc.want {
+				t.Errorf("onecall %v, charts %v; want %v", cfg.Weather.OneCall, cfg.Channels.Charts, tc.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvLists(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		tokens   string
+		admins   string
+		wantKeys []string
+		wantIDs  []int64
+		wantErr  bool
+	}{
+		{name: "trimmed", tokens: " a, b ,c", admins: "1, 2", wantKeys: []string{"a", "b", "c"}, wantIDs: []int64{1, 2}},
+		{name: "empty items", tokens: "a,,", admins: ",3,", wantKeys: []string{"a"}, wantIDs: []int64{3}},
+		{name: "cleared", tokens: "", admins: "", wantKeys: nil, wantIDs: nil},
+		{name: "bad id", tokens: "a", admins: "1,x", wantErr: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("OPENWEATHER_TOKENS", tc.tokens)
+			t.Setenv("ADMIN_IDS", tc.admins)
+			cfg := defaultConfig()
+			cfg.Weather.APIKeys = []string{"from-file"}
+			cfg.Telegram.AdminIDs = []int64{42}
+			err := cfg.applyEnv()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("no error for a bad ADMIN_IDS")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg.Weather.APIKeys, tc.wantKeys) {
+				t.Errorf("api keys %q, want %q", cfg.Weather.APIKeys, tc.wantKeys)
+			}
+			if !reflect.DeepEqual(cfg.Telegram.AdminIDs, tc.wantIDs) {
+				t.Errorf("admin ids %v, want %v", cfg.Telegram.AdminIDs, tc.wantIDs)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		change  func(c *Config)
+		wantErr string // "" when the config is valid
+	}{
+		{name: "defaults", change: func(c *Config) {}},
+		{
+			name:    "lead equals current cache",
+			change:  func(c *Config) { c.Schedule.PrefetchLead = c.Cache.Current },
+			wantErr: "schedule.prefetch_lead",
+		},
+		{
+			name: "lead longer than forecast cache",
+			change: func(c *Config) {
+				c.Cache.Current = time.Hour
+				c.Schedule.PrefetchLead = c.Cache.Forecast + time.Minute
+			},
+			wantErr: "schedule.prefetch_lead",
+		},
+		{
+			name: "lead under both caches",
+			change: func(c *Config) {
+				c.Cache.Current, c.Cache.Forecast = 20*time.Minute, 20*time.Minute
+				c.Schedule.PrefetchLead = 15 * time.Minute
+			},
+		},
+		{
+			name:    "negative quota",
+			change:  func(c *Config) { c.Weather.Quota.OneCallPerDay = -1 },
+			wantErr: "weather.quota",
+		},
+		{
+			name:    "no api key",
+			change:  func(c *Config) { c.Weather.APIKey = "" },
+			wantErr: "ключ API погоды",
+		},
+		{
+			name:   "keys only in the list",
+			change: func(c *Config) { c.Weather.APIKey, c.Weather.APIKeys = "", []string{"k"} },
+		},
+		{
+			name: "webhook secret",
+			change: func(c *Config) {
+				c.Webhook.URL, c.Webhook.Secret = "https://example.com/hook", "Abc_1-2"
+			},
+		},
+		{
+			name: "webhook secret with spaces",
+			change: func(c *Config) {
+				c.Webhook.URL, c.Webhook.Secret = "https://example.com/hook", "not a token"
+			},
+			wantErr: "webhook.secret",
+		},
+		{
+			name:    "plain http webhook",
+			change:  func(c *Config) { c.Webhook.URL = "http://example.com/hook" },
+			wantErr: "webhook.url",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.Telegram.Token = "token"
+			cfg.Weather.APIKey = "key"
+			tc.change(cfg)
+			err := cfg.validate()
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("got error %v, want one about %s", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRestartOnly(t *testing.T) {
+	cur := defaultConfig()
+	next := defaultConfig()
+	next.Weather.Quota.PerMinute = 120
+	next.Schedule.MorningHour = 7
+	next.Cache.Current = time.Minute
+	if got := cur.restartOnly(next); len(got) != 0 {
+		t.Errorf("reloadable changes reported as restart-only: %v", got)
+	}
+
+	next.Weather.Timeout = time.Minute
+	next.Webhook.Secret = "s"
+	next.Log.Format = "json"
+	want := []string{"weather.timeout", "webhook", "log.format"}
+	if got := cur.restartOnly(next); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
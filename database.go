@@ -2,17 +2,19 @@ package main
 
 import (
     "database/sql"
-    "log"
+    "errors"
+    "log/slog"
+    "time"
 
     _ "modernc.org/sqlite"
 )
 
 type DB = sql.DB
 
-func InitDB() *DB {
-    db, err := sql.Open("sqlite", "weather.db")
+func InitDB(path string) *DB {
+    db, err := sql.Open("sqlite", path)
     if err != nil {
-        log.Fatal(err)
+        fatal("Ошибка инициализации базы", "err", err)
     }
 
     _, err = db.Exec(`CREATE TABLE IF NOT EXISTS users (
@@ -20,7 +22,7 @@ func InitDB() *DB {
         city TEXT
     )`)
     if err != nil {
-        log.Fatal(err)
+        fatal("Ошибка инициализации базы", "err", err)
     }
 
     _, err = db.Exec(`CREATE TABLE IF NOT EXISTS subscriptions (
@@ -30,49 +32,170 @@ func InitDB() *DB {
         PRIMARY KEY(user_id, sub_type)
     )`)
     if err != nil {
-        log.Fatal(err)
+        fatal("Ошибка инициализации базы", "err", err)
+    }
+
+    addColumn(db, "users", "quiet_from", "INTEGER")
+    addColumn(db, "users", "quiet_to", "INTEGER")
+    addColumn(db, "users", "astro_block", "INTEGER DEFAULT 0")
+    addColumn(db, "users", "lang", "TEXT")
+    addColumn(db, "users", "active", "INTEGER DEFAULT 1")
+
+    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS rain_episodes (
+        user_id INTEGER PRIMARY KEY,
+        notified_at INTEGER
+    )`)
+    if err != nil {
+        fatal("Ошибка инициализации базы", "err", err)
+    }
+
+    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS alert_settings (
+        user_id INTEGER PRIMARY KEY,
+        frost REAL,
+        heat REAL,
+        gust REAL,
+        precip REAL,
+        thunder INTEGER
+    )`)
+    if err != nil {
+        fatal("Ошибка инициализации базы", "err", err)
+    }
+
+    addColumn(db, "alert_settings", "aqi", "INTEGER DEFAULT 0")
+
+    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS alerts_sent (
+        user_id INTEGER,
+        event_key TEXT,
+        sent_at INTEGER,
+        PRIMARY KEY(user_id, event_key)
+    )`)
+    if err != nil {
+        fatal("Ошибка инициализации базы", "err", err)
+    }
+
+    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS channels (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        chat_id TEXT UNIQUE,
+        city TEXT,
+        lat REAL,
+        lon REAL,
+        timezone TEXT
+    )`)
+    if err != nil {
+        fatal("Ошибка инициализации базы", "err", err)
+    }
+
+    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS channel_posts (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        channel_id INTEGER,
+        post_type TEXT,
+        schedule TEXT,
+        template TEXT
+    )`)
+    if err != nil {
+        fatal("Ошибка инициализации базы", "err", err)
+    }
+
+    addColumn(db, "channel_posts", "message_id", "INTEGER")
+
+    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS send_failures (
+        chat TEXT,
+        code INTEGER,
+        error TEXT,
+        failed_at INTEGER
+    )`)
+    if err != nil {
+        fatal("Ошибка инициализации базы", "err", err)
+    }
+
+    _, err = db.Exec(`CREATE TABLE IF NOT EXISTS admins (
+        user_id INTEGER PRIMARY KEY
+    )`)
+    if err != nil {
+        fatal("Ошибка инициализации базы", "err", err)
     }
 
     return db
 }
 
+// addColumn adds a column to an existing table unless it is already there,
+// so databases created by older versions pick up new fields.
+func addColumn(db *DB, table, column, def string) {
+    rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+    if err != nil {
+        fatal("Ошибка инициализации базы", "err", err)
+    }
+    defer rows.Close()
+    for rows.Next() {
+        var name string
+        if err := rows.Scan(&name); err != nil {
+            fatal("Ошибка инициализации базы", "err", err)
+        }
+        if name == column {
+            return
+        }
+    }
+    if _, err := db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + def); err != nil {
+        fatal("Ошибка инициализации базы", "err", err)
+    }
+}
+
+// dbExec runs a statement whose result nobody needs and logs it when it
+// fails; callers can't do anything better about a broken write.
+func dbExec(db *DB, op string, query string, args ...any) {
+    if _, err := db.Exec(query, args...); err != nil {
+        slog.Error("Ошибка базы данных", "op", op, "err", err)
+    }
+}
+
+// logDBError logs an error a helper swallows. A missing row is an answer,
+// not an error.
+func logDBError(op string, err error) {
+    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+        slog.Error("Ошибка базы данных", "op", op, "err", err)
+    }
+}
+
 func SetUserCity(db *DB, userID int64, city string) {
-    _, _ = db.Exec(`
+    dbExec(db, "SetUserCity", `
         INSERT INTO users (user_id, city) VALUES (?, ?)
         ON CONFLICT(user_id) DO UPDATE SET city=excluded.city
     `, userID, city)
 }
 
+// GetUserCity returns "" when the user has no city yet; rows created by
+// the other settings leave city NULL.
 func GetUserCity(db *DB, userID int64) string {
-    var city string
-    _ = db.QueryRow("SELECT city FROM users WHERE user_id = ?", userID).Scan(&city)
-    return city
+    var city sql.NullString
+    logDBError("GetUserCity", db.QueryRow("SELECT city FROM users WHERE user_id = ?", userID).Scan(&city))
+    return city.String
 }
 
 func SetSubscription(db *DB, userID int64, subType string) {
     if subType == "custom" {
         return
     }
-    _, _ = db.Exec(`
+    dbExec(db, "SetSubscription", `
         INSERT INTO subscriptions (user_id, sub_type) VALUES (?, ?)
         ON CONFLICT(user_id, sub_type) DO NOTHING
     `, userID, subType)
 }
 
 func SetCustomHour(db *DB, userID int64, hour int) {
-    _, _ = db.Exec(`
+    dbExec(db, "SetCustomHour", `
         INSERT INTO subscriptions (user_id, sub_type, custom_hour) VALUES (?, 'custom', ?)
         ON CONFLICT(user_id, sub_type) DO UPDATE SET custom_hour=excluded.custom_hour
     `, userID, hour)
 }
 
 func UnsetSpecificSubscription(db *DB, userID int64, subType string) {
-    _, _ = db.Exec("DELETE FROM subscriptions WHERE user_id = ? AND sub_type = ?", userID, subType)
+    dbExec(db, "UnsetSpecificSubscription", "DELETE FROM subscriptions WHERE user_id = ? AND sub_type = ?", userID, subType)
 }
 
 func GetUserSubscriptions(db *DB, userID int64) []string {
     rows, err := db.Query("SELECT sub_type FROM subscriptions WHERE user_id = ?", userID)
     if err != nil {
+        logDBError("GetUserSubscriptions", err)
         return nil
     }
     defer rows.Close()
@@ -80,25 +203,29 @@ func GetUserSubscriptions(db *DB, userID int64) []string {
     var subs []string
     for rows.Next() {
         var sub string
-        if err := rows.Scan(&sub); err == nil {
-            subs = append(subs, sub)
+        if err := rows.Scan(&sub); err != nil {
+            logDBError("GetUserSubscriptions", err)
+            continue
         }
+        subs = append(subs, sub)
     }
     return subs
 }
 
 func GetCustomHour(db *DB, userID int64) int {
-    var hour int
+    var hour sql.NullInt64
     err := db.QueryRow("SELECT custom_hour FROM subscriptions WHERE user_id = ? AND sub_type = 'custom'", userID).Scan(&hour)
-    if err != nil {
+    if err != nil || !hour.Valid {
+        logDBError("GetCustomHour", err)
         return -1
     }
-    return hour
+    return int(hour.Int64)
 }
 
 func GetSubscribers(db *DB, subType string) []int64 {
-    rows, err := db.Query("SELECT user_id FROM subscriptions WHERE sub_type = ?", subType)
+    rows, err := db.Query("SELECT user_id FROM subscriptions WHERE sub_type = ? AND user_id NOT IN (SELECT user_id FROM users WHERE active = 0)", subType)
     if err != nil {
+        logDBError("GetSubscribers", err)
         return nil
     }
     defer rows.Close()
@@ -106,16 +233,19 @@ func GetSubscribers(db *DB, subType string) []int64 {
     var users []int64
     for rows.Next() {
         var id int64
-        if err := rows.Scan(&id); err == nil {
-            users = append(users, id)
+        if err := rows.Scan(&id); err != nil {
+            logDBError("GetSubscribers", err)
+            continue
         }
+        users = append(users, id)
     }
     return users
 }
 
 func GetSubscribersByHour(db *DB, hour int) []int64 {
-    rows, err := db.Query("SELECT user_id FROM subscriptions WHERE sub_type = 'custom' AND custom_hour = ?", hour)
+    rows, err := db.Query("SELECT user_id FROM subscriptions WHERE sub_type = 'custom' AND custom_hour = ? AND user_id NOT IN (SELECT user_id FROM users WHERE active = 0)", hour)
     if err != nil {
+        logDBError("GetSubscribersByHour", err)
         return nil
     }
     defer rows.Close()
@@ -123,9 +253,372 @@ func GetSubscribersByHour(db *DB, hour int) []int64 {
     var users []int64
     for rows.Next() {
         var id int64
-        if err := rows.Scan(&id); err == nil {
-            users = append(users, id)
+        if err := rows.Scan(&id); err != nil {
+            logDBError("GetSubscribersByHour", err)
+            continue
         }
+        users = append(users, id)
     }
     return users
-}
\ No newline at end of file
+}
+
+func GetAlertSettings(db *DB, userID int64) AlertSettings {
+    s := defaultAlertSettings
+    var thunder int
+    err := db.QueryRow("SELECT frost, heat, gust, precip, thunder, aqi FROM alert_settings WHERE user_id = ?", userID).
+        Scan(&s.Frost, &s.Heat, &s.Gust, &s.Precip, &thunder, &s.AQI)
+    if err != nil {
+        logDBError("GetAlertSettings", err)
+        return defaultAlertSettings
+    }
+    s.Thunder = thunder != 0
+    return s
+}
+
+func SetAlertSettings(db *DB, userID int64, s AlertSettings) {
+    thunder := 0
+    if s.Thunder {
+        thunder = 1
+    }
+    dbExec(db, "SetAlertSettings", `
+        INSERT INTO alert_settings (user_id, frost, heat, gust, precip, thunder, aqi) VALUES (?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(user_id) DO UPDATE SET frost=excluded.frost, heat=excluded.heat, gust=excluded.gust,
+            precip=excluded.precip, thunder=excluded.thunder, aqi=excluded.aqi
+    `, userID, s.Frost, s.Heat, s.Gust, s.Precip, thunder, s.AQI)
+}
+
+// MarkAlertSent records an alert event for the user and reports whether it
+// is new, i.e. was not announced before.
+func MarkAlertSent(db *DB, userID int64, eventKey string) bool {
+    res, err := db.Exec(`
+        INSERT INTO alerts_sent (user_id, event_key, sent_at) VALUES (?, ?, ?)
+        ON CONFLICT(user_id, event_key) DO NOTHING
+    `, userID, eventKey, time.Now().Unix())
+    if err != nil {
+        logDBError("MarkAlertSent", err)
+        return false
+    }
+    n, err := res.RowsAffected()
+    logDBError("MarkAlertSent", err)
+    return n > 0
+}
+
+func PruneSentAlerts(db *DB, before time.Time) {
+    dbExec(db, "PruneSentAlerts", "DELETE FROM alerts_sent WHERE sent_at < ?", before.Unix())
+}
+
+// GetQuietHours returns the user's quiet period as local hours [from, to).
+// ok is false when no quiet hours are set.
+func GetQuietHours(db *DB, userID int64) (from, to int, ok bool) {
+    var f, t sql.NullInt64
+    err := db.QueryRow("SELECT quiet_from, quiet_to FROM users WHERE user_id = ?", userID).Scan(&f, &t)
+    logDBError("GetQuietHours", err)
+    if err != nil || !f.Valid || !t.Valid {
+        return 0, 0, false
+    }
+    return int(f.Int64), int(t.Int64), true
+}
+
+func SetQuietHours(db *DB, userID int64, from, to int) {
+    dbExec(db, "SetQuietHours", `
+        INSERT INTO users (user_id, quiet_from, quiet_to) VALUES (?, ?, ?)
+        ON CONFLICT(user_id) DO UPDATE SET quiet_from=excluded.quiet_from, quiet_to=excluded.quiet_to
+    `, userID, from, to)
+}
+
+func ClearQuietHours(db *DB, userID int64) {
+    dbExec(db, "ClearQuietHours", "UPDATE users SET quiet_from = NULL, quiet_to = NULL WHERE user_id = ?", userID)
+}
+
+func RainEpisodeNotified(db *DB, userID int64) bool {
+    var n int
+    err := db.QueryRow("SELECT COUNT(*) FROM rain_episodes WHERE user_id = ?", userID).Scan(&n)
+    logDBError("RainEpisodeNotified", err)
+    return err == nil && n > 0
+}
+
+func MarkRainEpisode(db *DB, userID int64) {
+    dbExec(db, "MarkRainEpisode", `
+        INSERT INTO rain_episodes (user_id, notified_at) VALUES (?, ?)
+        ON CONFLICT(user_id) DO NOTHING
+    `, userID, time.Now().Unix())
+}
+
+func ClearRainEpisode(db *DB, userID int64) {
+    dbExec(db, "ClearRainEpisode", "DELETE FROM rain_episodes WHERE user_id = ?", userID)
+}
+
+// GetAstroBlock reports whether scheduled messages for the user include the
+// sun and moon section.
+func GetAstroBlock(db *DB, userID int64) bool {
+    var enabled sql.NullInt64
+    err := db.QueryRow("SELECT astro_block FROM users WHERE user_id = ?", userID).Scan(&enabled)
+    logDBError("GetAstroBlock", err)
+    return err == nil && enabled.Int64 != 0
+}
+
+func SetAstroBlock(db *DB, userID int64, enabled bool) {
+    v := 0
+    if enabled {
+        v = 1
+    }
+    dbExec(db, "SetAstroBlock", `
+        INSERT INTO users (user_id, astro_block) VALUES (?, ?)
+        ON CONFLICT(user_id) DO UPDATE SET astro_block=excluded.astro_block
+    `, userID, v)
+}
+
+// SetUserLanguage stores the Telegram language code of the user's client.
+func SetUserLanguage(db *DB, userID int64, lang string) {
+    dbExec(db, "SetUserLanguage", `
+        INSERT INTO users (user_id, lang) VALUES (?, ?)
+        ON CONFLICT(user_id) DO UPDATE SET lang=excluded.lang
+    `, userID, lang)
+}
+
+func GetUserLanguage(db *DB, userID int64) string {
+    var lang sql.NullString
+    logDBError("GetUserLanguage", db.QueryRow("SELECT lang FROM users WHERE user_id = ?", userID).Scan(&lang))
+    return lang.String
+}
+
+// ListChannels returns every configured channel with its posts.
+func ListChannels(db *DB) []channelConfig {
+    rows, err := db.Query("SELECT id, chat_id, city, lat, lon, timezone FROM channels ORDER BY id")
+    if err != nil {
+        logDBError("ListChannels", err)
+        return nil
+    }
+    defer rows.Close()
+
+    var channels []channelConfig
+    byID := make(map[int64]int)
+    for rows.Next() {
+        var ch channelConfig
+        var lat, lon sql.NullFloat64
+        if err := rows.Scan(&ch.ID, &ch.ChatID, &ch.City, &lat, &lon, &ch.Timezone); err != nil {
+            logDBError("ListChannels", err)
+            continue
+        }
+        ch.Lat, ch.Lon, ch.HasCoords = lat.Float64, lon.Float64, lat.Valid && lon.Valid
+        byID[ch.ID] = len(channels)
+        channels = append(channels, ch)
+    }
+
+    posts, err := db.Query("SELECT id, channel_id, post_type, schedule, COALESCE(template, ''), COALESCE(message_id, 0) FROM channel_posts ORDER BY id")
+    if err != nil {
+        logDBError("ListChannels", err)
+        return channels
+    }
+    defer posts.Close()
+    for posts.Next() {
+        var p channelPost
+        if err := posts.Scan(&p.ID, &p.ChannelID, &p.Type, &p.Schedule, &p.Template, &p.MessageID); err != nil {
+            logDBError("ListChannels", err)
+            continue
+        }
+        if i, ok := byID[p.ChannelID]; ok {
+            channels[i].Posts = append(channels[i].Posts, p)
+        }
+    }
+    return channels
+}
+
+func AddChannel(db *DB, chatID, city, timezone string) (int64, error) {
+    res, err := db.Exec("INSERT INTO channels (chat_id, city, timezone) VALUES (?, ?, ?)", chatID, city, timezone)
+    if err != nil {
+        return 0, err
+    }
+    return res.LastInsertId()
+}
+
+// SetChannelCoords pins the channel to coordinates, which are used instead
+// of the city name for requests.
+func SetChannelCoords(db *DB, id int64, lat, lon float64) bool {
+    res, err := db.Exec("UPDATE channels SET lat = ?, lon = ? WHERE id = ?", lat, lon, id)
+    logDBError("SetChannelCoords", err)
+    return err == nil && rowsAffected(res) > 0
+}
+
+func DeleteChannel(db *DB, id int64) bool {
+    res, err := db.Exec("DELETE FROM channels WHERE id = ?", id)
+    logDBError("DeleteChannel", err)
+    if err != nil || rowsAffected(res) == 0 {
+        return false
+    }
+    dbExec(db, "DeleteChannel", "DELETE FROM channel_posts WHERE channel_id = ?", id)
+    return true
+}
+
+func AddChannelPost(db *DB, channelID int64, postType, schedule, template string) int64 {
+    res, err := db.Exec("INSERT INTO channel_posts (channel_id, post_type, schedule, template) VALUES (?, ?, ?, ?)",
+        channelID, postType, schedule, template)
+    if err != nil {
+        logDBError("AddChannelPost", err)
+        return 0
+    }
+    id, err := res.LastInsertId()
+    logDBError("AddChannelPost", err)
+    return id
+}
+
+// SetChannelPostMessage remembers the message a live post keeps editing.
+func SetChannelPostMessage(db *DB, postID int64, messageID int) {
+    dbExec(db, "SetChannelPostMessage", "UPDATE channel_posts SET message_id = ? WHERE id = ?", messageID, postID)
+}
+
+func DeleteChannelPost(db *DB, id int64) bool {
+    res, err := db.Exec("DELETE FROM channel_posts WHERE id = ?", id)
+    logDBError("DeleteChannelPost", err)
+    return err == nil && rowsAffected(res) > 0
+}
+
+func rowsAffected(res sql.Result) int64 {
+    n, err := res.RowsAffected()
+    logDBError("RowsAffected", err)
+    return n
+}
+
+func AddAdmin(db *DB, userID int64) {
+    dbExec(db, "AddAdmin", "INSERT OR IGNORE INTO admins (user_id) VALUES (?)", userID)
+}
+
+func RemoveAdmin(db *DB, userID int64) bool {
+    res, err := db.Exec("DELETE FROM admins WHERE user_id = ?", userID)
+    logDBError("RemoveAdmin", err)
+    return err == nil && rowsAffected(res) > 0
+}
+
+// ListAdmins returns the admins added with /admin_add; the ones from
+// ADMIN_IDS are not stored.
+func ListAdmins(db *DB) []int64 {
+    rows, err := db.Query("SELECT user_id FROM admins ORDER BY user_id")
+    if err != nil {
+        logDBError("ListAdmins", err)
+        return nil
+    }
+    defer rows.Close()
+
+    var ids []int64
+    for rows.Next() {
+        var id int64
+        if err := rows.Scan(&id); err != nil {
+            logDBError("ListAdmins", err)
+            continue
+        }
+        ids = append(ids, id)
+    }
+    return ids
+}
+
+func IsStoredAdmin(db *DB, userID int64) bool {
+    var id int64
+    err := db.QueryRow("SELECT user_id FROM admins WHERE user_id = ?", userID).Scan(&id)
+    logDBError("IsStoredAdmin", err)
+    return err == nil
+}
+
+// CountUsers returns the number of known users and how many of them have
+// chosen a city.
+func CountUsers(db *DB) (total, withCity int) {
+    logDBError("CountUsers", db.QueryRow("SELECT COUNT(*), COUNT(NULLIF(city, '')) FROM users").Scan(&total, &withCity))
+    return total, withCity
+}
+
+// CountActiveUsers returns the number of users who haven't blocked the bot.
+func CountActiveUsers(db *DB) int {
+    var n int
+    logDBError("CountActiveUsers", db.QueryRow("SELECT COUNT(*) FROM users WHERE COALESCE(active, 1) != 0").Scan(&n))
+    return n
+}
+
+// CountSubscriptions returns the number of active subscribers per sub_type.
+func CountSubscriptions(db *DB) map[string]int {
+    counts := make(map[string]int)
+    rows, err := db.Query(`
+        SELECT sub_type, COUNT(*) FROM subscriptions
+        WHERE user_id NOT IN (SELECT user_id FROM users WHERE active = 0)
+        GROUP BY sub_type
+    `)
+    if err != nil {
+        logDBError("CountSubscriptions", err)
+        return counts
+    }
+    defer rows.Close()
+
+    for rows.Next() {
+        var subType string
+        var n int
+        if err := rows.Scan(&subType, &n); err != nil {
+            logDBError("CountSubscriptions", err)
+            continue
+        }
+        counts[subType] = n
+    }
+    return counts
+}
+
+// GetBroadcastAudience returns the users matching the announcement
+// filters; empty filters match everyone. Cities are typed by hand, so they
+// are compared like place keys: trimmed and case-insensitive. SQLite's
+// lower() only folds ASCII, hence the comparison in Go.
+func GetBroadcastAudience(db *DB, city, subType, lang string) []int64 {
+    rows, err := db.Query(`
+        SELECT user_id, COALESCE(city, '') FROM users u
+        WHERE COALESCE(active, 1) != 0
+          AND (? = '' OR lang = ? OR lang LIKE ? || '-%')
+          AND (? = '' OR EXISTS (SELECT 1 FROM subscriptions s WHERE s.user_id = u.user_id AND s.sub_type = ?))
+        ORDER BY user_id
+    `, lang, lang, lang, subType, subType)
+    if err != nil {
+        logDBError("GetBroadcastAudience", err)
+        return nil
+    }
+    defer rows.Close()
+
+    want := cityPlace(city).key()
+    var users []int64
+    for rows.Next() {
+        var id int64
+        var userCity string
+        if err := rows.Scan(&id, &userCity); err != nil {
+            logDBError("GetBroadcastAudience", err)
+            continue
+        }
+        if want != "" && cityPlace(userCity).key() != want {
+            continue
+        }
+        users = append(users, id)
+    }
+    return users
+}
+
+// SetUserActive marks whether the bot can reach the user. Subscriptions of
+// inactive users are kept but skipped until the user comes back.
+func SetUserActive(db *DB, userID int64, active bool) {
+    v := 0
+    if active {
+        v = 1
+    }
+    dbExec(db, "SetUserActive", `
+        INSERT INTO users (user_id, active) VALUES (?, ?)
+        ON CONFLICT(user_id) DO UPDATE SET active=excluded.active
+    `, userID, v)
+}
+
+func IsUserActive(db *DB, userID int64) bool {
+    var active sql.NullInt64
+    logDBError("IsUserActive", db.QueryRow("SELECT active FROM users WHERE user_id = ?", userID).Scan(&active))
+    return !active.Valid || active.Int64 != 0
+}
+
+// RecordSendFailure stores a message Telegram refused or that could not be
+// delivered after all retries. code is 0 for network errors.
+func RecordSendFailure(db *DB, chat string, code int, message string) {
+    dbExec(db, "RecordSendFailure", "INSERT INTO send_failures (chat, code, error, failed_at) VALUES (?, ?, ?, ?)",
+        chat, code, message, time.Now().Unix())
+}
+
+func PruneSendFailures(db *DB, before time.Time) {
+    dbExec(db, "PruneSendFailures", "DELETE FROM send_failures WHERE failed_at < ?", before.Unix())
+}
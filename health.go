@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	// maxPollAge is how long polling may go without a successful
+	// getUpdates; a long poll returns at least every pollTimeout seconds.
+	maxPollAge = 3 * time.Minute
+	// tickGrace is added to twice a loop's interval before it counts as
+	// stuck, so a slow run is not reported at once.
+	tickGrace   = time.Minute
+	dbPingLimit = 2 * time.Second
+	// weatherFailLimit is how many weather API calls in a row must fail
+	// before the API counts as down; a single error is no reason to take
+	// the bot out of service.
+	weatherFailLimit = 3
+)
+
+// health is what the readiness check knows about the running bot.
+var health = &healthState{ticks: make(map[string]loopTick), started: time.Now()}
+
+type loopTick struct {
+	last     time.Time
+	interval time.Duration
+}
+
+type healthState struct {
+	mu          sync.Mutex
+	started     time.Time
+	lastUpdates time.Time
+	weatherAt   time.Time
+	weatherErr  string
+	weatherFail int // consecutive failed calls
+	ticks       map[string]loopTick
+}
+
+// recordUpdates marks a successful getUpdates or an accepted webhook call.
+func (h *healthState) recordUpdates() {
+	h.mu.Lock()
+	h.lastUpdates = time.Now()
+	h.mu.Unlock()
+}
+
+// recordWeatherCall counts the outcome of a weather API call, as
+// classified by the client. A 404 is an unknown city or place, and One
+// Call answers 401 to keys without its subscription, which the provider
+// reports as errNotSupported: neither means the provider is down.
+func (h *healthState) recordWeatherCall(endpoint string, err error) {
+	var apiErr *apiError
+	if err != nil && errors.As(err, &apiErr) && (apiErr.Status == http.StatusNotFound ||
+		endpoint == "onecall" && apiErr.Status == http.StatusUnauthorized) {
+		err = nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.weatherAt = time.Now()
+	if err == nil {
+		h.weatherErr, h.weatherFail = "", 0
+		return
+	}
+	h.weatherErr = err.Error()
+	h.weatherFail++
+}
+
+func (h *healthState) recordTick(name string, interval time.Duration) {
+	h.mu.Lock()
+	h.ticks[name] = loopTick{last: time.Now(), interval: interval}
+	h.mu.Unlock()
+}
+
+// healthCheck is one line of the /readyz report.
+type healthCheck struct {
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// check runs the readiness checks. polling is false in webhook mode, where
+// updates only arrive when someone writes to the bot.
+func (h *healthState) check(ctx context.Context, db *DB, polling bool) map[string]healthCheck {
+	checks := make(map[string]healthCheck)
+
+	ctx, cancel := context.WithTimeout(ctx, dbPingLimit)
+	defer cancel()
+	if _, err := db.ExecContext(ctx, "SELECT 1"); err != nil {
+		checks["database"] = healthCheck{Detail: err.Error()}
+	} else {
+		checks["database"] = healthCheck{OK: true, Detail: "доступна"}
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+
+	if polling {
+		since := h.lastUpdates
+		if since.IsZero() {
+			since = h.started
+		}
+		age := now.Sub(since).Round(time.Second)
+		checks["updates"] = healthCheck{
+			OK:     age <= maxPollAge,
+			Detail: fmt.Sprintf("последний опрос %s назад", age),
+		}
+	}
+
+	switch {
+	case h.weatherAt.IsZero():
+		checks["weather_api"] = healthCheck{OK: true, Detail: "ещё не вызывался"}
+	case h.weatherFail >= weatherFailLimit:
+		checks["weather_api"] = healthCheck{Detail: fmt.Sprintf("%d ошибок подряд, последняя: %s", h.weatherFail, h.weatherErr)}
+	case h.weatherFail > 0:
+		checks["weather_api"] = healthCheck{OK: true, Detail: "последний вызов с ошибкой: " + h.weatherErr}
+	default:
+		checks["weather_api"] = healthCheck{OK: true,
+			Detail: fmt.Sprintf("последний вызов %s назад", now.Sub(h.weatherAt).Round(time.Second))}
+	}
+
+	names := make([]string, 0, len(h.ticks))
+	for name := range h.ticks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := h.ticks[name]
+		age := now.Sub(t.last).Round(time.Second)
+		checks["scheduler_"+name] = healthCheck{
+			OK:     age <= 2*t.interval+tickGrace,
+			Detail: fmt.Sprintf("последний запуск %s назад, интервал %s", age, t.interval),
+		}
+	}
+	return checks
+}
+
+// statusServer serves /metrics and the health checks.
+type statusServer struct {
+	server *http.Server
+}
+
+func startStatusServer(cfg ServerConfig, db *DB, polling bool) *statusServer {
+	if cfg.Listen == "" {
+		return nil
+	}
+	prometheus.MustRegister(newDBCollector(db))
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		checks := health.check(r.Context(), db, polling)
+		status, code := "ok", http.StatusOK
+		for _, c := range checks {
+			if !c.OK {
+				status, code = "fail", http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, code, map[string]any{"status": status, "checks": checks})
+	})
+
+	s := &statusServer{server: &http.Server{Addr: cfg.Listen, Handler: mux}}
+	go func() {
+		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			slog.Error("Ошибка служебного HTTP-сервера", "err", err)
+		}
+	}()
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+func (s *statusServer) Close(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	return s.server.Shutdown(ctx)
+}
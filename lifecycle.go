@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout bounds how long shutdown waits for running jobs and
+// queued messages.
+const shutdownTimeout = 30 * time.Second
+
+// jobs tracks the background loops, broadcasts and answers to weather
+// requests, so shutdown can let them finish the run they are in.
+var jobs sync.WaitGroup
+
+// runEvery calls fn in the background right away and then every interval
+// until ctx is cancelled. interval is asked after every run, so a reloaded
+// setting applies from the next one. A run that has started is never
+// interrupted: fn gets a context that keeps ctx's values but is not
+// cancelled with it. Its weather requests count as scheduled for the
+// quota. Every finished run is reported to the health checks under name.
+func runEvery(ctx context.Context, name string, interval func() time.Duration, fn func(ctx context.Context)) {
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		runCtx := scheduledContext(context.WithoutCancel(ctx))
+		for {
+			fn(runCtx)
+			every := interval()
+			health.recordTick(name, every)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(every):
+			}
+		}
+	}()
+}
+
+// cleanupInterval is how often startCleanup prunes old records.
+const cleanupInterval = time.Hour
+
+// startCleanup prunes the records that only matter for a while, on its
+// own schedule so no other job has to be healthy for it to run.
+func startCleanup(ctx context.Context, db *DB) {
+	runEvery(ctx, "cleanup", every(cleanupInterval), func(ctx context.Context) {
+		PruneSendFailures(db, time.Now().Add(-sendFailureRetention))
+	})
+}
+
+// every is a fixed runEvery interval.
+func every(d time.Duration) func() time.Duration {
+	return func() time.Duration { return d }
+}
+
+// shutdown stops receiving updates and handles those already received
+// with drain, waits for the jobs in progress and the outbound queue, then
+// closes the database. Whatever is still running after shutdownTimeout is
+// abandoned.
+func shutdown(db *DB, receiver updateReceiver, status *statusServer, drain func(ctx context.Context)) {
+	slog.Info("Остановка бота")
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := receiver.Close(ctx); err != nil {
+		slog.Error("Ошибка остановки получения обновлений", "err", err)
+	}
+	// Telegram counts these updates as delivered, so they would be lost.
+	drain(ctx)
+
+	done := make(chan struct{})
+	go func() {
+		jobs.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Warn("Не все задачи завершились до остановки")
+	}
+
+	if err := outbound.Flush(ctx); err != nil {
+		slog.Warn("Очередь сообщений не отправлена полностью", "err", err)
+	}
+
+	if err := status.Close(ctx); err != nil {
+		slog.Error("Ошибка остановки служебного сервера", "err", err)
+	}
+
+	if err := db.Close(); err != nil {
+		slog.Error("Ошибка закрытия базы", "err", err)
+	}
+	slog.Info("Бот остановлен")
+}
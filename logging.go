@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// logLevel is shared by the handler so /reload can change the level
+// without restarting.
+var logLevel = new(slog.LevelVar)
+
+// setupLogging installs the default slog logger. The standard log package
+// writes through it too.
+func setupLogging(cfg LogConfig) {
+	opts := &slog.HandlerOptions{Level: logLevel}
+	var h slog.Handler
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		h = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+	setLogLevel(cfg)
+}
+
+func setLogLevel(cfg LogConfig) {
+	level, _ := parseLogLevel(cfg.Level)
+	logLevel.Set(level)
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(strings.ToUpper(s)))
+	return level, err
+}
+
+// fatal logs the error and exits, like log.Fatal.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
-    "log"
+    "context"
+    "flag"
+    "log/slog"
     "os"
+    "os/signal"
     "strconv"
-    "time"
+    "sync"
+    "syscall"
 
     tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
     "github.com/joho/godotenv"
@@ -14,186 +18,340 @@ var bot *tgbotapi.BotAPI
 
 var awaitingCityInput = make(map[int64]bool)
 var awaitingCustomTime = make(map[int64]bool)
-var menuState = make(map[int64]string)
 
-var channelID string
+// menuState is the menu each chat is in. Answers to weather requests are
+// sent from the background and may switch it, so it is read and set
+// through currentMenu and setMenu.
+var (
+    menuMu    sync.Mutex
+    menuState = make(map[int64]string)
+)
+
+func currentMenu(chatID int64) string {
+    menuMu.Lock()
+    defer menuMu.Unlock()
+    return menuState[chatID]
+}
+
+func setMenu(chatID int64, state string) {
+    menuMu.Lock()
+    menuState[chatID] = state
+    menuMu.Unlock()
+}
 
 func main() {
+    configPath := flag.String("config", "", "файл конфигурации (.yaml или .toml), по умолчанию CONFIG_FILE или config.yaml")
+    flag.Parse()
+
     _ = godotenv.Load()
 
-    channelID = os.Getenv("CHANNEL_ID")
-    if channelID == "" {
-        log.Panic("CHANNEL_ID не задан в .env")
+    cfg, err := loadConfig(configFile(*configPath))
+    if err != nil {
+        fatal("Ошибка конфигурации", "err", err)
+    }
+    setupLogging(cfg.Log)
+    configureProviders(cfg.Weather, cfg.Cache)
+
+    if err := loadTemplates(cfg.Templates.Dir); err != nil {
+        fatal("Ошибка загрузки шаблонов", "err", err)
     }
 
-    var err error
-    bot, err = tgbotapi.NewBotAPI(os.Getenv("TELEGRAM_TOKEN"))
+    bot, err = tgbotapi.NewBotAPI(cfg.Telegram.Token)
     if err != nil {
-        log.Panic(err)
+        fatal("Ошибка подключения к Telegram", "err", err)
     }
+    slog.Info("Бот запущен", "username", bot.Self.UserName)
 
-    db := InitDB()
-    go startScheduler(db)
-    go startChannelScheduler()
+    db := InitDB(cfg.Database.Path)
+    outbound = startOutbox(db)
+    seedDefaultChannel(db, cfg.Channels)
 
-    u := tgbotapi.NewUpdate(0)
-    u.Timeout = 60
-    updates := bot.GetUpdatesChan(u)
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
 
-    for update := range updates {
-        if update.Message == nil {
-            continue
+    setSchedule(cfg.Schedule)
+    startScheduler(ctx, db)
+    startChannelScheduler(ctx, db)
+    startAlertScheduler(ctx, db)
+    startNowcastWatcher(ctx, db)
+    startCleanup(ctx, db)
+
+    updates, receiver, err := receiveUpdates(cfg.Webhook)
+    if err != nil {
+        fatal("Ошибка получения обновлений", "err", err)
+    }
+    status := startStatusServer(cfg.Server, db, cfg.Webhook.URL == "")
+
+    for {
+        select {
+        case <-ctx.Done():
+            shutdown(db, receiver, status, func(ctx context.Context) {
+                drainUpdates(ctx, updates, func(update tgbotapi.Update) { handleUpdate(ctx, db, cfg, update) })
+            })
+            return
+        case update := <-updates:
+            handleUpdate(ctx, db, cfg, update)
         }
+    }
+}
+
+// handleUpdate routes one update from the user to its handler.
+func handleUpdate(ctx context.Context, db *DB, cfg *Config, update tgbotapi.Update) {
+    updatesTotal.WithLabelValues(updateType(update)).Inc()
+    if chat := update.FromChat(); chat != nil {
+        slog.Debug("Обновление", "update_id", update.UpdateID, "type", updateType(update), "chat_id", chat.ID)
+    }
+
+    if update.MyChatMember != nil {
+        handleMyChatMember(db, update.MyChatMember)
+        return
+    }
+
+    if update.CallbackQuery != nil {
+        handleCallback(ctx, db, update.CallbackQuery)
+        return
+    }
+
+    if update.Message == nil {
+        return
+    }
+
+    chatID := update.Message.Chat.ID
+    text := update.Message.Text
+
+    if text == "/start" && !IsUserActive(db, chatID) {
+        SetUserActive(db, chatID, true)
+    }
+
+    if update.Message.IsCommand() && handleAdminCommand(ctx, db, cfg, update.Message) {
+        return
+    }
+
+    if _, ok := pendingBroadcasts[chatID]; ok {
+        handleBroadcastConfirm(ctx, chatID, text)
+        return
+    }
 
-        chatID := update.Message.Chat.ID
-        text := update.Message.Text
+    if from := update.Message.From; from != nil && from.LanguageCode != "" {
+        SetUserLanguage(db, chatID, from.LanguageCode)
+    }
 
-        if update.Message.Location != nil {
-            lat, lon := update.Message.Location.Latitude, update.Message.Location.Longitude
-            forecast, cityName, err := getWeatherByCoordsAndCity(lat, lon)
+    if update.Message.Location != nil {
+        lat, lon := update.Message.Location.Latitude, update.Message.Location.Longitude
+        inBackground(ctx, func(ctx context.Context) {
+            forecast, cityName, err := getWeatherByCoordsAndCity(ctx, lat, lon)
             if err != nil {
-                bot.Send(tgbotapi.NewMessage(chatID, "Ошибка при получении прогноза по геолокации."))
-                continue
+                post(tgbotapi.NewMessage(chatID, userMessage(err)))
+                return
             }
             SetUserCity(db, chatID, cityName)
-            bot.Send(tgbotapi.NewMessage(chatID, "Город сохранён: "+cityName))
-            bot.Send(tgbotapi.NewMessage(chatID, forecast))
-            continue
+            post(tgbotapi.NewMessage(chatID, "Город сохранён: "+cityName))
+            post(forecast.message(chatID))
+        })
+        return
+    }
+
+    if menu := currentMenu(chatID); (menu == "forecast" || menu == "subs" || menu == "citySelection") && text == "🔙 Назад" {
+        showMainMenu(chatID)
+        return
+    }
+
+    if awaitingCityInput[chatID] {
+        SetUserCity(db, chatID, text)
+        awaitingCityInput[chatID] = false
+        post(tgbotapi.NewMessage(chatID, "Город сохранён: "+text))
+        showMainMenu(chatID)
+        return
+    }
+
+    if _, ok := awaitingAlertInput[chatID]; ok {
+        if text == "🔙 Назад" {
+            delete(awaitingAlertInput, chatID)
+            showAlertsMenu(db, chatID)
+            return
         }
+        handleAlertInput(db, chatID, text)
+        return
+    }
 
-        if (menuState[chatID] == "forecast" || menuState[chatID] == "subs" || menuState[chatID] == "citySelection") && text == "🔙 Назад" {
-            showMainMenu(chatID)
-            continue
+    if awaitingQuietHours[chatID] {
+        if text == "🔙 Назад" {
+            delete(awaitingQuietHours, chatID)
+            showAlertsMenu(db, chatID)
+            return
         }
+        handleQuietHoursInput(db, chatID, text)
+        return
+    }
 
-        if awaitingCityInput[chatID] {
-            SetUserCity(db, chatID, text)
-            awaitingCityInput[chatID] = false
-            bot.Send(tgbotapi.NewMessage(chatID, "Город сохранён: "+text))
-            showMainMenu(chatID)
-            continue
+    if awaitingCustomTime[chatID] {
+        hour, err := strconv.Atoi(text)
+        if err != nil || hour < 0 || hour > 23 {
+            post(tgbotapi.NewMessage(chatID, "Введите корректный час от 0 до 23"))
+            return
         }
+        SetCustomHour(db, chatID, hour)
+        awaitingCustomTime[chatID] = false
+        SetSubscription(db, chatID, "custom")
+        post(tgbotapi.NewMessage(chatID, "Подписка включена на "+strconv.Itoa(hour)+":00"))
+        showSubscriptionsMenu(chatID)
+        return
+    }
 
-        if awaitingCustomTime[chatID] {
-            hour, err := strconv.Atoi(text)
-            if err != nil || hour < 0 || hour > 23 {
-                bot.Send(tgbotapi.NewMessage(chatID, "Введите корректный час от 0 до 23"))
-                continue
-            }
-            SetCustomHour(db, chatID, hour)
-            awaitingCustomTime[chatID] = false
-            SetSubscription(db, chatID, "custom")
-            bot.Send(tgbotapi.NewMessage(chatID, "Подписка включена на "+strconv.Itoa(hour)+":00"))
+    switch currentMenu(chatID) {
+    case "main":
+        switch text {
+        case "📍 Погода сейчас":
+            serveWeather(ctx, db, chatID, requestCurrent)
+
+        case "📅 Прогнозы":
+            showForecastMenu(chatID)
+
+        case "⏰ Подписки":
             showSubscriptionsMenu(chatID)
-            continue
+
+        case "🏙 Выбор города":
+            showCitySelectionMenu(chatID)
+
+        case "❗ Оповещения":
+            showAlertsMenu(db, chatID)
+
+        case "🌅 Солнце и Луна":
+            serveWeather(ctx, db, chatID, requestAstro)
+
+        case "🌫 Качество воздуха":
+            serveWeather(ctx, db, chatID, requestAir)
+
+        default:
+            post(tgbotapi.NewMessage(chatID, "Пожалуйста, выберите опцию из меню."))
         }
 
-        switch menuState[chatID] {
-        case "main":
-            switch text {
-            case "📍 Погода сейчас":
-                city := GetUserCity(db, chatID)
-                if city == "" {
-                    bot.Send(tgbotapi.NewMessage(chatID, "Сначала задайте город!"))
-                    continue
-                }
-                msg, _ := getWeather(city)
-                bot.Send(tgbotapi.NewMessage(chatID, msg))
-
-            case "📅 Прогнозы":
-                showForecastMenu(chatID)
-
-            case "⏰ Подписки":
-                showSubscriptionsMenu(chatID)
-
-            case "🏙 Выбор города":
-                showCitySelectionMenu(chatID)
-
-            default:
-                bot.Send(tgbotapi.NewMessage(chatID, "Пожалуйста, выберите опцию из меню."))
-            }
+    case "forecast":
+        if GetUserCity(db, chatID) == "" {
+            post(tgbotapi.NewMessage(chatID, "Сначала задайте город!"))
+            showMainMenu(chatID)
+            return
+        }
+        switch text {
+        case "⏱ По часам":
+            showHourlyMenu(chatID)
+        case "📅 На завтра":
+            serveWeather(ctx, db, chatID, requestTomorrow)
+        case "📆 На неделю":
+            serveWeather(ctx, db, chatID, requestWeekly)
+        case "📈 График на сутки":
+            serveWeather(ctx, db, chatID, requestChartDay)
+        case "📈 График на неделю":
+            serveWeather(ctx, db, chatID, requestChartWeek)
+        case "🔙 Назад":
+            showMainMenu(chatID)
+        default:
+            post(tgbotapi.NewMessage(chatID, "Выберите вариант из меню."))
+        }
 
-        case "forecast":
-            city := GetUserCity(db, chatID)
-            if city == "" {
-                bot.Send(tgbotapi.NewMessage(chatID, "Сначала задайте город!"))
-                showMainMenu(chatID)
-                continue
-            }
-            switch text {
-            case "⏱ Через час":
-                msg, _ := getHourlyForecast(city)
-                bot.Send(tgbotapi.NewMessage(chatID, msg))
-            case "📅 На завтра":
-                msg, _ := getTomorrowForecast(city)
-                bot.Send(tgbotapi.NewMessage(chatID, msg))
-            case "📆 На неделю":
-                msg, _ := getWeeklyForecast(city)
-                bot.Send(tgbotapi.NewMessage(chatID, msg))
-            case "🔙 Назад":
-                showMainMenu(chatID)
-            default:
-                bot.Send(tgbotapi.NewMessage(chatID, "Выберите вариант из меню."))
+    case "hourly":
+        if GetUserCity(db, chatID) == "" {
+            post(tgbotapi.NewMessage(chatID, "Сначала задайте город!"))
+            showMainMenu(chatID)
+            return
+        }
+        if text == "🔙 Назад" {
+            showForecastMenu(chatID)
+            return
+        }
+        hours, ok := parseHorizon(text)
+        if !ok {
+            post(tgbotapi.NewMessage(chatID, "Выберите горизонт прогноза из меню."))
+            return
+        }
+        serveWeather(ctx, db, chatID, requestHourly+strconv.Itoa(hours))
+
+    case "subs":
+        switch text {
+        case "📋 Мои подписки":
+            showMySubscriptions(db, chatID)
+
+        case "⏰ Утро":
+            SetSubscription(db, chatID, "утро")
+            post(tgbotapi.NewMessage(chatID, "Подписка: утро (8:00) включена"))
+
+        case "🌙 Вечер":
+            SetSubscription(db, chatID, "вечер")
+            post(tgbotapi.NewMessage(chatID, "Подписка: вечер (20:00) включена"))
+
+        case "🌫 Воздух":
+            SetSubscription(db, chatID, airSubscription)
+            post(tgbotapi.NewMessage(chatID, "Подписка: качество воздуха (8:00) включена"))
+
+        case "🌅 Солнце и Луна в рассылке":
+            enabled := !GetAstroBlock(db, chatID)
+            SetAstroBlock(db, chatID, enabled)
+            if enabled {
+                post(tgbotapi.NewMessage(chatID, "Блок «Солнце и Луна» добавлен в рассылку"))
+            } else {
+                post(tgbotapi.NewMessage(chatID, "Блок «Солнце и Луна» убран из рассылки"))
             }
 
-        case "subs":
-            switch text {
-            case "📋 Мои подписки":
-                showMySubscriptions(db, chatID)
+        case "🕐 Выбрать время":
+            awaitingCustomTime[chatID] = true
+            post(tgbotapi.NewMessage(chatID, "Введите час от 0 до 23 для подписки"))
 
-            case "⏰ Утро":
-                SetSubscription(db, chatID, "утро")
-                bot.Send(tgbotapi.NewMessage(chatID, "Подписка: утро (8:00) включена"))
+        case "❌ Отписаться от утра":
+            UnsetSpecificSubscription(db, chatID, "утро")
+            post(tgbotapi.NewMessage(chatID, "Подписка на утро отключена"))
 
-            case "🌙 Вечер":
-                SetSubscription(db, chatID, "вечер")
-                bot.Send(tgbotapi.NewMessage(chatID, "Подписка: вечер (20:00) включена"))
+        case "❌ Отписаться от вечера":
+            UnsetSpecificSubscription(db, chatID, "вечер")
+            post(tgbotapi.NewMessage(chatID, "Подписка на вечер отключена"))
 
-            case "🕐 Выбрать время":
-                awaitingCustomTime[chatID] = true
-                bot.Send(tgbotapi.NewMessage(chatID, "Введите час от 0 до 23 для подписки"))
+        case "❌ Отписаться от выбранного времени":
+            UnsetSpecificSubscription(db, chatID, "custom")
+            post(tgbotapi.NewMessage(chatID, "Кастомная подписка отключена"))
 
-            case "❌ Отписаться от утра":
-                UnsetSpecificSubscription(db, chatID, "утро")
-                bot.Send(tgbotapi.NewMessage(chatID, "Подписка на утро отключена"))
+        case "❌ Отписаться от воздуха":
+            UnsetSpecificSubscription(db, chatID, airSubscription)
+            post(tgbotapi.NewMessage(chatID, "Подписка на качество воздуха отключена"))
 
-            case "❌ Отписаться от вечера":
-                UnsetSpecificSubscription(db, chatID, "вечер")
-                bot.Send(tgbotapi.NewMessage(chatID, "Подписка на вечер отключена"))
+        case "❌ Отписаться от оповещений":
+            UnsetSpecificSubscription(db, chatID, alertSubscription)
+            post(tgbotapi.NewMessage(chatID, "Оповещения о непогоде отключены"))
 
-            case "❌ Отписаться от выбранного времени":
-                UnsetSpecificSubscription(db, chatID, "custom")
-                bot.Send(tgbotapi.NewMessage(chatID, "Кастомная подписка отключена"))
+        case "❌ Отписаться от «дождь скоро»":
+            UnsetSpecificSubscription(db, chatID, rainSubscription)
+            ClearRainEpisode(db, chatID)
+            post(tgbotapi.NewMessage(chatID, "Уведомления о скором дожде отключены"))
 
-            case "🔙 Назад":
-                showMainMenu(chatID)
+        case "🔙 Назад":
+            showMainMenu(chatID)
 
-            default:
-                bot.Send(tgbotapi.NewMessage(chatID, "Выберите вариант из меню."))
-            }
+        default:
+            post(tgbotapi.NewMessage(chatID, "Выберите вариант из меню."))
+        }
 
-        case "citySelection":
-            switch text {
-            case "🏙 Установить город вручную":
-                awaitingCityInput[chatID] = true
-                bot.Send(tgbotapi.NewMessage(chatID, "Введите город вручную:"))
+    case "alerts":
+        handleAlertsMenu(db, chatID, text)
 
-            case "🔙 Назад":
-                showMainMenu(chatID)
+    case "citySelection":
+        switch text {
+        case "🏙 Установить город вручную":
+            awaitingCityInput[chatID] = true
+            post(tgbotapi.NewMessage(chatID, "Введите город вручную:"))
 
-            default:
-                bot.Send(tgbotapi.NewMessage(chatID, "Выберите вариант из меню или отправьте геолокацию."))
-            }
+        case "🔙 Назад":
+            showMainMenu(chatID)
 
         default:
-            showMainMenu(chatID)
+            post(tgbotapi.NewMessage(chatID, "Выберите вариант из меню или отправьте геолокацию."))
         }
+
+    default:
+        showMainMenu(chatID)
     }
 }
 
 
 func showMainMenu(chatID int64) {
-    menuState[chatID] = "main"
+    setMenu(chatID, "main")
     msg := tgbotapi.NewMessage(chatID, "Главное меню")
     msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
         tgbotapi.NewKeyboardButtonRow(
@@ -204,30 +362,70 @@ func showMainMenu(chatID int64) {
             tgbotapi.NewKeyboardButton("⏰ Подписки"),
             tgbotapi.NewKeyboardButton("🏙 Выбор города"),
         ),
+        tgbotapi.NewKeyboardButtonRow(
+            tgbotapi.NewKeyboardButton("❗ Оповещения"),
+            tgbotapi.NewKeyboardButton("🌫 Качество воздуха"),
+        ),
+        tgbotapi.NewKeyboardButtonRow(
+            tgbotapi.NewKeyboardButton("🌅 Солнце и Луна"),
+        ),
     )
-    bot.Send(msg)
+    post(msg)
 }
 
 func showForecastMenu(chatID int64) {
-    menuState[chatID] = "forecast"
+    setMenu(chatID, "forecast")
     msg := tgbotapi.NewMessage(chatID, "Выберите прогноз")
     msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
         tgbotapi.NewKeyboardButtonRow(
-            tgbotapi.NewKeyboardButton("⏱ Через час"),
+            tgbotapi.NewKeyboardButton("⏱ По часам"),
             tgbotapi.NewKeyboardButton("📅 На завтра"),
         ),
         tgbotapi.NewKeyboardButtonRow(
             tgbotapi.NewKeyboardButton("📆 На неделю"),
         ),
+        tgbotapi.NewKeyboardButtonRow(
+            tgbotapi.NewKeyboardButton("📈 График на сутки"),
+            tgbotapi.NewKeyboardButton("📈 График на неделю"),
+        ),
+        tgbotapi.NewKeyboardButtonRow(
+            tgbotapi.NewKeyboardButton("🔙 Назад"),
+        ),
+    )
+    post(msg)
+}
+
+func showHourlyMenu(chatID int64) {
+    setMenu(chatID, "hourly")
+    row := []tgbotapi.KeyboardButton{}
+    for _, h := range hourlyHorizons {
+        row = append(row, tgbotapi.NewKeyboardButton(horizonLabel(h)))
+    }
+    msg := tgbotapi.NewMessage(chatID, "На сколько часов вперёд?")
+    msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
+        row,
         tgbotapi.NewKeyboardButtonRow(
             tgbotapi.NewKeyboardButton("🔙 Назад"),
         ),
     )
-    bot.Send(msg)
+    post(msg)
+}
+
+func horizonLabel(hours int) string {
+    return strconv.Itoa(hours) + " ч"
+}
+
+func parseHorizon(text string) (int, bool) {
+    for _, h := range hourlyHorizons {
+        if text == horizonLabel(h) {
+            return h, true
+        }
+    }
+    return 0, false
 }
 
 func showSubscriptionsMenu(chatID int64) {
-    menuState[chatID] = "subs"
+    setMenu(chatID, "subs")
     msg := tgbotapi.NewMessage(chatID, "Меню подписок")
     msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
         tgbotapi.NewKeyboardButtonRow(
@@ -239,16 +437,20 @@ func showSubscriptionsMenu(chatID int64) {
         ),
         tgbotapi.NewKeyboardButtonRow(
             tgbotapi.NewKeyboardButton("🕐 Выбрать время"),
+            tgbotapi.NewKeyboardButton("🌫 Воздух"),
+        ),
+        tgbotapi.NewKeyboardButtonRow(
+            tgbotapi.NewKeyboardButton("🌅 Солнце и Луна в рассылке"),
         ),
         tgbotapi.NewKeyboardButtonRow(
             tgbotapi.NewKeyboardButton("🔙 Назад"),
         ),
     )
-    bot.Send(msg)
+    post(msg)
 }
 
 func showCitySelectionMenu(chatID int64) {
-    menuState[chatID] = "citySelection"
+    setMenu(chatID, "citySelection")
     msg := tgbotapi.NewMessage(chatID, "Выбор города")
     msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
         tgbotapi.NewKeyboardButtonRow(
@@ -261,13 +463,13 @@ func showCitySelectionMenu(chatID int64) {
             tgbotapi.NewKeyboardButton("🔙 Назад"),
         ),
     )
-    bot.Send(msg)
+    post(msg)
 }
 
 func showMySubscriptions(db *DB, chatID int64) {
     subs := GetUserSubscriptions(db, chatID)
     if len(subs) == 0 {
-        bot.Send(tgbotapi.NewMessage(chatID, "У тебя нет активных подписок."))
+        post(tgbotapi.NewMessage(chatID, "У тебя нет активных подписок."))
         return
     }
 
@@ -286,6 +488,15 @@ func showMySubscriptions(db *DB, chatID int64) {
             hour := GetCustomHour(db, chatID)
             text += "✅ Выбранное время: " + strconv.Itoa(hour) + ":00\n"
             rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("❌ Отписаться от выбранного времени")))
+        case airSubscription:
+            text += "✅ Качество воздуха (8:00)\n"
+            rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("❌ Отписаться от воздуха")))
+        case alertSubscription:
+            text += "✅ Оповещения о непогоде\n"
+            rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("❌ Отписаться от оповещений")))
+        case rainSubscription:
+            text += "✅ Дождь скоро (☂️)\n"
+            rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("❌ Отписаться от «дождь скоро»")))
         }
     }
 
@@ -296,93 +507,38 @@ func showMySubscriptions(db *DB, chatID int64) {
         Keyboard:       rows,
         ResizeKeyboard: true,
     }
-    bot.Send(msg)
+    post(msg)
 }
 
 
-func startScheduler(db *DB) {
-    go func() {
-        for {
-            now := time.Now()
-            hour := now.Hour()
-
-            if hour == 8 {
-                subs := GetSubscribers(db, "утро")
-                sendWeatherToUsers(db, subs)
-            }
-
-            if hour == 20 {
-                subs := GetSubscribers(db, "вечер")
-                sendWeatherToUsers(db, subs)
-            }
-
-            subs := GetSubscribersByHour(db, hour)
-            sendWeatherToUsers(db, subs)
-
-            time.Sleep(time.Minute)
-        }
-    }()
-}
-
-func sendWeatherToUsers(db *DB, users []int64) {
-    for _, userID := range users {
+func sendWeatherToUsers(ctx context.Context, db *DB, subType string, users []int64) {
+    forEach(users, deliveryWorkers, func(userID int64) {
         city := GetUserCity(db, userID)
         if city == "" {
-            continue
+            return
         }
-        forecast, err := getWeather(city)
+        logger := slog.With("job", "subscriptions", "subscription", subType, "chat_id", userID, "city", city)
+        current, err := fetchCurrent(ctx, cityPlace(city), false)
         if err != nil {
-            continue
+            logger.Warn("Ошибка получения погоды", "err", err)
+            countDelivery("subscription", err)
+            return
         }
-        msg := tgbotapi.NewMessage(userID, "Прогноз погоды:\n"+forecast)
-        bot.Send(msg)
-    }
-}
-
-
-func startChannelScheduler() {
-    go func() {
-        for {
-            now := time.Now()
-            hour, min := now.Hour(), now.Minute()
-
-            if min == 0 {
-                sendHourlyWeatherToChannel()
+        view := scheduledView{Current: current}
+        if GetAstroBlock(db, userID) {
+            if astro, err := astronomyData(ctx, city); err != nil {
+                logger.Warn("Ошибка получения астрономических данных", "err", err)
+            } else {
+                view.Astro = astro
             }
-
-            if hour == 7 && min == 0 {
-                sendDailyForecastToChannel()
-            }
-
-            time.Sleep(time.Second * 30)
         }
-    }()
-}
-
-func sendDailyForecastToChannel() {
-    city := "Симферополь"
-    msg, err := getWeeklyForecast(city)
-    if err != nil {
-        log.Println("Ошибка получения недельного прогноза для канала:", err)
-        return
-    }
-    fullMsg := "🌤 Прогноз погоды на неделю для " + city + ":\n" + msg
-    m := tgbotapi.NewMessageToChannel(channelID, fullMsg)
-    if _, err := bot.Send(m); err != nil {
-        log.Println("Ошибка отправки недельного прогноза в канал:", err)
-    }
+        msg, err := renderTemplate("scheduled", view)
+        if err != nil {
+            logger.Error("Ошибка шаблона", "err", err)
+        } else {
+            _, err = send(msg.message(userID))
+        }
+        countDelivery("subscription", err)
+    })
 }
 
-func sendHourlyWeatherToChannel() {
-    city := "Симферополь"
-    msg, err := getWeather(city)
-    if err != nil {
-        log.Println("Ошибка получения текущей погоды для канала:", err)
-        return
-    }
-    fullMsg := "⏰ Текущая погода в " + city + ":\n" + msg
-    m := tgbotapi.NewMessageToChannel(channelID, fullMsg)
-    if _, err := bot.Send(m); err != nil {
-        log.Println("Ошибка отправки текущей погоды в канал:", err)
-    }
-}
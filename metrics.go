@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	updatesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherbot_updates_total",
+		Help: "Telegram updates handled, by type.",
+	}, []string{"type"})
+
+	weatherRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherbot_weather_api_requests_total",
+		Help: "Weather API requests by endpoint and HTTP status (\"error\" when no response).",
+	}, []string{"endpoint", "status"})
+
+	weatherRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "weatherbot_weather_api_request_duration_seconds",
+		Help:    "Weather API request latency by endpoint.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	cacheRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherbot_cache_requests_total",
+		Help: "Provider cache lookups by cache and result (hit or miss).",
+	}, []string{"cache", "result"})
+
+	scheduledDeliveriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherbot_scheduled_deliveries_total",
+		Help: "Scheduled messages by kind and result (sent or failed).",
+	}, []string{"kind", "result"})
+
+	telegramErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherbot_telegram_send_errors_total",
+		Help: "Failed Telegram requests by error code (0 for network errors), retries included.",
+	}, []string{"code"})
+
+	quotaRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weatherbot_weather_quota_rejections_total",
+		Help: "Weather API calls refused by the local quota, by priority (interactive or scheduled).",
+	}, []string{"priority"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		updatesTotal,
+		weatherRequestsTotal,
+		weatherRequestDuration,
+		cacheRequestsTotal,
+		scheduledDeliveriesTotal,
+		telegramErrorsTotal,
+		quotaRejectionsTotal,
+	)
+}
+
+// updateType names the kind of update for updatesTotal.
+func updateType(u tgbotapi.Update) string {
+	switch {
+	case u.MyChatMember != nil:
+		return "my_chat_member"
+	case u.CallbackQuery != nil:
+		return "callback_query"
+	case u.Message == nil:
+		return "other"
+	case u.Message.Location != nil:
+		return "location"
+	case u.Message.IsCommand():
+		return "command"
+	}
+	return "message"
+}
+
+// observeWeatherRequest records one weather API call.
+func observeWeatherRequest(endpoint string, start time.Time, resp *http.Response, err error) {
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	weatherRequestsTotal.WithLabelValues(endpoint, status).Inc()
+	weatherRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+}
+
+// countDelivery records the outcome of one scheduled message; err covers
+// both fetching the data and sending.
+func countDelivery(kind string, err error) {
+	result := "sent"
+	if err != nil {
+		result = "failed"
+	}
+	scheduledDeliveriesTotal.WithLabelValues(kind, result).Inc()
+}
+
+func countTelegramError(err error) {
+	code := 0
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) {
+		code = tgErr.Code
+	}
+	telegramErrorsTotal.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+// dbCollector reports gauges read from the database on every scrape.
+type dbCollector struct {
+	db            *DB
+	activeUsers   *prometheus.Desc
+	subscriptions *prometheus.Desc
+}
+
+func newDBCollector(db *DB) *dbCollector {
+	return &dbCollector{
+		db: db,
+		activeUsers: prometheus.NewDesc("weatherbot_active_users",
+			"Users the bot can reach.", nil, nil),
+		subscriptions: prometheus.NewDesc("weatherbot_subscriptions",
+			"Active users' subscriptions by type.", []string{"type"}, nil),
+	}
+}
+
+func (c *dbCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.activeUsers
+	ch <- c.subscriptions
+}
+
+func (c *dbCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(c.activeUsers, prometheus.GaugeValue, float64(CountActiveUsers(c.db)))
+	for subType, n := range CountSubscriptions(c.db) {
+		ch <- prometheus.MustNewConstMetric(c.subscriptions, prometheus.GaugeValue, float64(n), subType)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// rainSubscription is the sub_type of users who want a heads-up shortly
+// before precipitation starts at their location.
+const rainSubscription = "зонтик"
+
+const (
+	nowcastLead = 60 * time.Minute
+
+	// minutelyWetThreshold is the minutely intensity in mm/h that counts
+	// as precipitation; lower values are drizzle noise in the nowcast.
+	minutelyWetThreshold = 0.1
+)
+
+var awaitingQuietHours = make(map[int64]bool)
+
+// rainOutlook describes precipitation at a location around now.
+type rainOutlook struct {
+	WetNow bool
+	Start  time.Time
+	Kind   string
+}
+
+// rainView is the data of the "rain" template.
+type rainView struct {
+	City    string
+	Kind    string
+	Minutes int
+	Start   time.Time
+}
+
+// Soon reports whether precipitation is expected to start within the lead
+// time while it is dry now.
+func (o rainOutlook) Soon() bool {
+	return !o.WetNow && !o.Start.IsZero()
+}
+
+// outlookFromMinutely uses the minutely nowcast: the latest sample not
+// after now tells if it is wet now, the first wet sample within lead is
+// the start.
+func outlookFromMinutely(samples []minutelyPrecip, now time.Time, lead time.Duration) rainOutlook {
+	var o rainOutlook
+	for _, m := range samples {
+		wet := m.Precipitation >= minutelyWetThreshold
+		if !m.Time.After(now) {
+			o.WetNow = wet
+			continue
+		}
+		if o.WetNow || m.Time.After(now.Add(lead)) {
+			break
+		}
+		if wet {
+			o.Start = m.Time
+			o.Kind = "осадки"
+			break
+		}
+	}
+	return o
+}
+
+// outlookFromForecast falls back to the 3-hour forecast: the entry covering
+// now tells if it is wet, the next wet entry starting within lead is the
+// start.
+func outlookFromForecast(data *forecastResponse, now time.Time, lead time.Duration) rainOutlook {
+	var o rainOutlook
+	for _, e := range data.List {
+		t := time.Unix(e.Dt, 0)
+		if !t.After(now) {
+			if now.Before(t.Add(forecastStep)) && e.precipKind() != "" {
+				o.WetNow = true
+				return o
+			}
+			continue
+		}
+		if t.After(now.Add(lead)) {
+			break
+		}
+		if kind := e.precipKind(); kind != "" {
+			o.Start = t
+			o.Kind = kind
+			return o
+		}
+	}
+	return o
+}
+
+// nowcast prefers the provider's minutely precipitation and uses the
+// forecast when the provider doesn't offer it. Any other error means the
+// outlook is unknown, which must not be taken for dry weather.
+func nowcast(ctx context.Context, data *forecastResponse, now time.Time) (rainOutlook, error) {
+	samples, err := provider.Minutely(ctx, data.City.Coord.Lat, data.City.Coord.Lon)
+	if err == nil {
+		return outlookFromMinutely(samples, now, nowcastLead), nil
+	}
+	if !errors.Is(err, errNotSupported) {
+		return rainOutlook{}, err
+	}
+	return outlookFromForecast(data, now, nowcastLead), nil
+}
+
+// inQuietHours reports whether the local hour falls into [from, to), which
+// may wrap around midnight.
+func inQuietHours(hour, from, to int) bool {
+	if from == to {
+		return false
+	}
+	if from < to {
+		return hour >= from && hour < to
+	}
+	return hour >= from || hour < to
+}
+
+func startNowcastWatcher(ctx context.Context, db *DB) {
+	interval := func() time.Duration { return currentSchedule().NowcastInterval }
+	runEvery(ctx, "nowcast", interval, func(ctx context.Context) { checkRainSoon(ctx, db) })
+}
+
+// checkRainSoon notifies subscribers once per rain episode. An episode
+// lasts while it rains or rain is imminent; the mark is cleared once the
+// outlook is dry again.
+func checkRainSoon(ctx context.Context, db *DB) {
+	byCity := make(map[string][]int64)
+	for _, userID := range GetSubscribers(db, rainSubscription) {
+		city := GetUserCity(db, userID)
+		if city == "" {
+			continue
+		}
+		byCity[city] = append(byCity[city], userID)
+	}
+
+	now := time.Now()
+	for city, users := range byCity {
+		data, err := fetchForecast(ctx, city)
+		if err != nil {
+			slog.Warn("Ошибка получения прогноза", "job", "nowcast", "city", city, "err", err)
+			continue
+		}
+		o, err := nowcast(ctx, data, now)
+		if err != nil {
+			// Skipped without touching the episode marks, so a failed
+			// call during rain doesn't start a new episode.
+			slog.Warn("Ошибка получения поминутного прогноза", "job", "nowcast", "city", city, "err", err)
+			continue
+		}
+		local := now.In(data.location())
+
+		for _, userID := range users {
+			notified := RainEpisodeNotified(db, userID)
+			switch {
+			case !o.WetNow && !o.Soon():
+				if notified {
+					ClearRainEpisode(db, userID)
+				}
+				continue
+			case notified:
+				continue
+			case o.WetNow:
+				// Already raining when we first saw it: too late for a
+				// warning, but it is still the same episode.
+				MarkRainEpisode(db, userID)
+				continue
+			}
+
+			if from, to, ok := GetQuietHours(db, userID); ok && inQuietHours(local.Hour(), from, to) {
+				continue
+			}
+
+			msg, err := renderTemplate("rain", rainView{
+				City:    data.City.Name,
+				Kind:    o.Kind,
+				Minutes: int(o.Start.Sub(now).Minutes()),
+				Start:   o.Start.In(data.location()),
+			})
+			if err != nil {
+				slog.Error("Ошибка шаблона", "job", "nowcast", "chat_id", userID, "err", err)
+			} else {
+				_, err = send(msg.message(userID))
+			}
+			countDelivery("rain", err)
+			MarkRainEpisode(db, userID)
+		}
+	}
+}
+
+func quietHoursLabel(db *DB, chatID int64) string {
+	from, to, ok := GetQuietHours(db, chatID)
+	if !ok {
+		return "не заданы"
+	}
+	return fmt.Sprintf("%d:00–%d:00", from, to)
+}
+
+// handleQuietHoursInput accepts "22-7" style ranges, or "нет" to clear.
+func handleQuietHoursInput(db *DB, chatID int64, text string) {
+	text = strings.TrimSpace(text)
+	if strings.EqualFold(text, "нет") {
+		ClearQuietHours(db, chatID)
+		delete(awaitingQuietHours, chatID)
+		showAlertsMenu(db, chatID)
+		return
+	}
+
+	parts := strings.Split(text, "-")
+	if len(parts) != 2 {
+		post(tgbotapi.NewMessage(chatID, "Введите тихие часы в формате 22-7 или «нет»"))
+		return
+	}
+	from, err1 := strconv.Atoi(strings.TrimSpace(parts[0]))
+	to, err2 := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err1 != nil || err2 != nil || from < 0 || from > 23 || to < 0 || to > 23 {
+		post(tgbotapi.NewMessage(chatID, "Часы должны быть от 0 до 23, например 22-7"))
+		return
+	}
+
+	SetQuietHours(db, chatID, from, to)
+	delete(awaitingQuietHours, chatID)
+	showAlertsMenu(db, chatID)
+}
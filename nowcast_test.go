@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutlookFromMinutely(t *testing.T) {
+	now := weatherStart
+	for _, tc := range []struct {
+		name      string
+		rates     map[int]float64 // mm/h by minute from now; the rest is dry
+		wetNow    bool
+		startsIn  int // minutes, -1 when no start is expected
+		wantsSoon bool
+	}{
+		{name: "dry", startsIn: -1},
+		{name: "wet now", rates: map[int]float64{0: 0.5, 10: 1}, wetNow: true, startsIn: -1},
+		{name: "wet a minute ago", rates: map[int]float64{-1: 0.5}, startsIn: -1},
+		{name: "starts soon", rates: map[int]float64{20: 0.4, 21: 2}, startsIn: 20, wantsSoon: true},
+		{name: "drizzle noise", rates: map[int]float64{5: minutelyWetThreshold / 2}, startsIn: -1},
+		{name: "at the lead", rates: map[int]float64{60: 1}, startsIn: 60, wantsSoon: true},
+		{name: "after the lead", rates: map[int]float64{61: 1}, startsIn: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var samples []minutelyPrecip
+			for m := -1; m <= 61; m++ {
+				samples = append(samples, minutelyPrecip{Time: now.Add(time.Duration(m) * time.Minute), Precipitation: tc.rates[m]})
+			}
+			o := outlookFromMinutely(samples, now, time.Hour)
+			if o.WetNow != tc.wetNow || o.Soon() != tc.wantsSoon {
+				t.Errorf("wet now %v, soon %v; want %v, %v", o.WetNow, o.Soon(), tc.wetNow, tc.wantsSoon)
+			}
+			if tc.startsIn >= 0 {
+				if want := now.Add(time.Duration(tc.startsIn) * time.Minute); !o.Start.Equal(want) || o.Kind != "осадки" {
+					t.Errorf("start %v (%q), want %v", o.Start, o.Kind, want)
+				}
+			} else if !o.Start.IsZero() {
+				t.Errorf("start %v, want none", o.Start)
+			}
+		})
+	}
+}
+
+func TestOutlookFromForecast(t *testing.T) {
+	// An hour into the first 3-hour entry.
+	now := weatherStart.Add(time.Hour)
+	rain := func(e *forecastEntry) { e.Rain.Volume = 1 }
+	snow := func(e *forecastEntry) { e.Snow.Volume = 2 }
+	for _, tc := range []struct {
+		name     string
+		wet      map[int]func(e *forecastEntry) // by entry index
+		lead     time.Duration
+		wetNow   bool
+		start    int // entry index, -1 for none
+		wantKind string
+	}{
+		{name: "dry", lead: 3 * time.Hour, start: -1},
+		{name: "wet now", wet: map[int]func(e *forecastEntry){0: rain}, lead: 3 * time.Hour, wetNow: true, start: -1},
+		{name: "rain next", wet: map[int]func(e *forecastEntry){1: rain}, lead: 3 * time.Hour, start: 1, wantKind: "дождь"},
+		{name: "snow next", wet: map[int]func(e *forecastEntry){1: snow}, lead: 3 * time.Hour, start: 1, wantKind: "снег"},
+		{name: "beyond the lead", wet: map[int]func(e *forecastEntry){1: rain}, lead: time.Hour, start: -1},
+		{name: "later entry", wet: map[int]func(e *forecastEntry){2: rain}, lead: 3 * time.Hour, start: -1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := &forecastResponse{}
+			for i := 0; i < 4; i++ {
+				e := testEntry(time.Duration(i)*forecastStep, 15, 0, "облачно")
+				if change := tc.wet[i]; change != nil {
+					change(&e)
+				}
+				data.List = append(data.List, e)
+			}
+			o := outlookFromForecast(data, now, tc.lead)
+			if o.WetNow != tc.wetNow {
+				t.Errorf("wet now %v, want %v", o.WetNow, tc.wetNow)
+			}
+			if tc.start < 0 {
+				if !o.Start.IsZero() {
+					t.Errorf("start %v, want none", o.Start)
+				}
+				return
+			}
+			want := weatherStart.Add(time.Duration(tc.start) * forecastStep)
+			if !o.Start.Equal(want) || o.Kind != tc.wantKind {
+				t.Errorf("start %v (%q), want %v (%q)", o.Start, o.Kind, want, tc.wantKind)
+			}
+		})
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	for _, tc := range []struct {
+		hour, from, to int
+		want           bool
+	}{
+		{hour: 23, from: 23, to: 7, want: true},
+		{hour: 3, from: 23, to: 7, want: true},
+		{hour: 7, from: 23, to: 7, want: false},
+		{hour: 22, from: 23, to: 7, want: false},
+		{hour: 13, from: 13, to: 15, want: true},
+		{hour: 15, from: 13, to: 15, want: false},
+		{hour: 12, from: 13, to: 15, want: false},
+		{hour: 5, from: 0, to: 0, want: false},
+	} {
+		if got := inQuietHours(tc.hour, tc.from, tc.to); got != tc.want {
+			t.Errorf("inQuietHours(%d, %d, %d) = %v, want %v", tc.hour, tc.from, tc.to, got, tc.want)
+		}
+	}
+}
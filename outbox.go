@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram limits: about 30 messages per second overall, one per second
+// in a private chat and 20 per minute in a group or channel. Short bursts
+// are allowed so a reply and the menu after it go out together.
+const (
+	globalSendRate   = 30
+	globalSendBurst  = 30
+	privateChatEvery = time.Second
+	groupChatEvery   = 3 * time.Second
+	chatSendBurst    = 3
+
+	sendAttempts       = 4
+	sendRetryBackoff   = time.Second
+	outboxChatsMaxIdle = 10 * time.Minute
+
+	// sendFailureRetention is how long failed requests are kept for
+	// inspection.
+	sendFailureRetention = 30 * 24 * time.Hour
+)
+
+// rateLimiter lets through one event per interval on average and up to
+// burst events at once.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	burst    int
+	next     time.Time
+}
+
+func newRateLimiter(interval time.Duration, burst int) *rateLimiter {
+	return &rateLimiter{interval: interval, burst: burst}
+}
+
+// reserve books the next slot and returns how long to wait for it.
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now) - time.Duration(l.burst-1)*l.interval
+	l.next = l.next.Add(l.interval)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+func (l *rateLimiter) wait() {
+	time.Sleep(l.reserve())
+}
+
+func (l *rateLimiter) idle() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Since(l.next) > outboxChatsMaxIdle
+}
+
+type outboundJob struct {
+	chat string
+	do   func() error
+	done chan error
+}
+
+// outbox is the queue every request to Telegram goes through. It applies
+// the global and per-chat limits, waits out 429 responses, retries
+// network and server errors with backoff and records requests that failed
+// for good. Each chat has its own queue served by its own goroutine, so
+// requests to one chat keep their order and a chat waiting out its limit
+// doesn't hold up the others.
+type outbox struct {
+	db     *DB
+	global *rateLimiter
+
+	mu     sync.Mutex
+	chats  map[string]*rateLimiter
+	queues map[string][]*outboundJob
+
+	pending sync.WaitGroup
+}
+
+var outbound *outbox
+
+func startOutbox(db *DB) *outbox {
+	return &outbox{
+		db:     db,
+		global: newRateLimiter(time.Second/globalSendRate, globalSendBurst),
+		chats:  make(map[string]*rateLimiter),
+		queues: make(map[string][]*outboundJob),
+	}
+}
+
+// send delivers a message through the outbox and waits for the result,
+// for callers that need the sent message or the error.
+func send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	var msg tgbotapi.Message
+	err := outbound.run(chatKey(c), func() error {
+		var err error
+		msg, err = bot.Send(c)
+		return err
+	})
+	return msg, err
+}
+
+// post queues a message without waiting for it. Replies to users go this
+// way so the update loop never waits for a chat's limit or retries;
+// failures are logged and recorded by the outbox.
+func post(c tgbotapi.Chattable) {
+	outbound.enqueue(&outboundJob{chat: chatKey(c), do: func() error {
+		_, err := bot.Send(c)
+		return err
+	}})
+}
+
+// request is send for methods that don't return a message, like pinning.
+func request(c tgbotapi.Chattable) error {
+	return outbound.run(chatKey(c), func() error {
+		_, err := bot.Request(c)
+		return err
+	})
+}
+
+func (o *outbox) run(chat string, do func() error) error {
+	job := &outboundJob{chat: chat, do: do, done: make(chan error, 1)}
+	o.enqueue(job)
+	return <-job.done
+}
+
+func (o *outbox) enqueue(job *outboundJob) {
+	o.pending.Add(1)
+	o.mu.Lock()
+	q, running := o.queues[job.chat]
+	o.queues[job.chat] = append(q, job)
+	o.mu.Unlock()
+	if !running {
+		go o.work(job.chat)
+	}
+}
+
+// work delivers the chat's queue in order and exits once it is empty.
+func (o *outbox) work(chat string) {
+	for {
+		o.mu.Lock()
+		q := o.queues[chat]
+		if len(q) == 0 {
+			delete(o.queues, chat)
+			o.mu.Unlock()
+			return
+		}
+		job := q[0]
+		o.queues[chat] = q[1:]
+		o.mu.Unlock()
+
+		err := o.deliver(job)
+		if job.done != nil {
+			job.done <- err
+		} else if err != nil {
+			slog.Warn("Ответ не доставлен", "chat_id", chat, "err", err)
+		}
+		o.pending.Done()
+	}
+}
+
+// Flush waits until every queued request is delivered or ctx expires.
+func (o *outbox) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		o.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (o *outbox) deliver(job *outboundJob) error {
+	var err error
+	var tgErr *tgbotapi.Error
+	backoff := sendRetryBackoff
+	for attempt := 1; attempt <= sendAttempts; attempt++ {
+		o.chatLimiter(job.chat).wait()
+		o.global.wait()
+
+		err = job.do()
+		if err == nil {
+			return nil
+		}
+		countTelegramError(err)
+
+		tgErr = nil
+		if errors.As(err, &tgErr) && tgErr.Code < 500 && tgErr.RetryAfter == 0 {
+			o.recordFailure(job.chat, tgErr)
+			return err
+		}
+		if attempt == sendAttempts {
+			break
+		}
+		if tgErr != nil && tgErr.RetryAfter > 0 {
+			time.Sleep(time.Duration(tgErr.RetryAfter) * time.Second)
+		} else {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+	}
+	slog.Error("Сообщение не отправлено", "chat_id", job.chat, "attempts", sendAttempts, "err", err)
+	code := 0
+	if tgErr != nil {
+		code = tgErr.Code
+	}
+	RecordSendFailure(o.db, job.chat, code, err.Error())
+	return err
+}
+
+// recordFailure stores a request Telegram refused. An edit that changes
+// nothing is not a failure. A user who blocked the bot is marked inactive
+// so scheduled messages stop until they come back.
+func (o *outbox) recordFailure(chat string, err *tgbotapi.Error) {
+	if strings.Contains(err.Message, "message is not modified") {
+		return
+	}
+	RecordSendFailure(o.db, chat, err.Code, err.Message)
+
+	if userID, perr := strconv.ParseInt(chat, 10, 64); perr == nil && userID > 0 && isBlockedError(err) {
+		slog.Info("Пользователь недоступен, подписки приостановлены", "chat_id", userID, "reason", err.Message)
+		SetUserActive(o.db, userID, false)
+	}
+}
+
+func (o *outbox) chatLimiter(chat string) *rateLimiter {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	l, ok := o.chats[chat]
+	if ok {
+		return l
+	}
+	if len(o.chats) > 1000 {
+		for k, v := range o.chats {
+			if v.idle() {
+				delete(o.chats, k)
+			}
+		}
+	}
+	every := privateChatEvery
+	if strings.HasPrefix(chat, "@") || strings.HasPrefix(chat, "-") {
+		every = groupChatEvery
+	}
+	l = newRateLimiter(every, chatSendBurst)
+	o.chats[chat] = l
+	return l
+}
+
+// chatKey returns the chat a request is addressed to, as the numeric id or
+// the channel username.
+func chatKey(c tgbotapi.Chattable) string {
+	var chatID int64
+	var username string
+	switch v := c.(type) {
+	case tgbotapi.MessageConfig:
+		chatID, username = v.ChatID, v.ChannelUsername
+	case tgbotapi.PhotoConfig:
+		chatID, username = v.ChatID, v.ChannelUsername
+	case tgbotapi.EditMessageTextConfig:
+		chatID, username = v.ChatID, v.ChannelUsername
+	case tgbotapi.PinChatMessageConfig:
+		chatID, username = v.ChatID, v.ChannelUsername
+	}
+	if username != "" {
+		return username
+	}
+	return strconv.FormatInt(chatID, 10)
+}
+
+// handleMyChatMember tracks users blocking and unblocking the bot in a
+// private chat, so subscriptions pause without waiting for a failed send.
+func handleMyChatMember(db *DB, u *tgbotapi.ChatMemberUpdated) {
+	if !u.Chat.IsPrivate() {
+		return
+	}
+	switch u.NewChatMember.Status {
+	case "kicked":
+		SetUserActive(db, u.Chat.ID, false)
+	case "member":
+		SetUserActive(db, u.Chat.ID, true)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Subscriptions are delivered in hourly slots. A few minutes before a
+// slot the planner fetches the weather of every location due in it into
+// the cache, so at the slot itself messages only have to be rendered and
+// sent.
+const (
+	prefetchWorkers = 8
+	deliveryWorkers = 8
+)
+
+// slotPlan is who gets which message at one slot.
+type slotPlan struct {
+	At      time.Time
+	Morning []int64
+	Evening []int64
+	Custom  []int64
+	Air     []int64
+}
+
+func planSlot(db *DB, schedule ScheduleConfig, at time.Time) slotPlan {
+	p := slotPlan{At: at, Custom: GetSubscribersByHour(db, at.Hour())}
+	if at.Hour() == schedule.MorningHour {
+		p.Morning = GetSubscribers(db, "утро")
+		p.Air = GetSubscribers(db, airSubscription)
+	}
+	if at.Hour() == schedule.EveningHour {
+		p.Evening = GetSubscribers(db, "вечер")
+	}
+	return p
+}
+
+// warmTask is one location to fetch and what its messages need.
+type warmTask struct {
+	City    string
+	Current bool
+	Astro   bool
+	Air     bool
+}
+
+// locations returns the distinct locations of the plan; users of the
+// same city share one task.
+func (p slotPlan) locations(db *DB) []*warmTask {
+	byKey := make(map[string]*warmTask)
+	var tasks []*warmTask
+	task := func(userID int64) *warmTask {
+		city := GetUserCity(db, userID)
+		if city == "" {
+			return nil
+		}
+		key := cityPlace(city).key()
+		t, ok := byKey[key]
+		if !ok {
+			t = &warmTask{City: city}
+			byKey[key] = t
+			tasks = append(tasks, t)
+		}
+		return t
+	}
+
+	for _, users := range [][]int64{p.Morning, p.Evening, p.Custom} {
+		for _, userID := range users {
+			if t := task(userID); t != nil {
+				t.Current = true
+				t.Astro = t.Astro || GetAstroBlock(db, userID)
+			}
+		}
+	}
+	for _, userID := range p.Air {
+		if t := task(userID); t != nil {
+			t.Air = true
+		}
+	}
+	return tasks
+}
+
+// warm fetches what the messages for the location will ask for; the
+// results stay in the provider caches.
+func warm(ctx context.Context, t *warmTask) error {
+	if t.Current {
+		if _, err := fetchCurrent(ctx, cityPlace(t.City), false); err != nil {
+			return err
+		}
+	}
+	if !t.Astro && !t.Air {
+		return nil
+	}
+	data, err := fetchForecast(ctx, t.City)
+	if err != nil {
+		return err
+	}
+	lat, lon := data.City.Coord.Lat, data.City.Coord.Lon
+	if t.Astro {
+		if _, err := provider.UVIndex(ctx, lat, lon); err != nil && !errors.Is(err, errNotSupported) {
+			return err
+		}
+	}
+	if t.Air {
+		if _, err := airProvider.AirQuality(ctx, lat, lon); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prefetch warms the cache for every location of the plan in parallel.
+// It gives up at ctx's deadline, the slot time: locations not reached by
+// then are fetched during delivery. Scheduled calls are paced by the quota
+// (1.25s apart with one key on the free plan), so a location costs one to
+// three paced calls and a lead of a few minutes covers a few hundred
+// calls; when the plan doesn't fit, the log says so.
+func prefetch(ctx context.Context, db *DB, plan slotPlan) {
+	start := time.Now()
+	tasks := plan.locations(db)
+	var failed, missed int
+	var mu sync.Mutex
+	forEach(tasks, prefetchWorkers, func(t *warmTask) {
+		if ctx.Err() != nil {
+			mu.Lock()
+			missed++
+			mu.Unlock()
+			return
+		}
+		if err := warm(ctx, t); err != nil {
+			mu.Lock()
+			if ctx.Err() != nil {
+				missed++
+			} else {
+				failed++
+				slog.Warn("Ошибка прогрева кэша", "job", "subscriptions", "city", t.City, "err", err)
+			}
+			mu.Unlock()
+		}
+	})
+	slog.Info("Кэш прогрет перед рассылкой", "job", "subscriptions", "slot", plan.At.Format("15:04"),
+		"locations", len(tasks), "failed", failed, "missed", missed, "duration", time.Since(start).Round(time.Millisecond))
+	if missed > 0 {
+		slog.Warn("Прогрев не уложился в schedule.prefetch_lead: увеличьте его, weather.quota.per_minute или число ключей",
+			"job", "subscriptions", "slot", plan.At.Format("15:04"), "missed", missed, "locations", len(tasks))
+	}
+}
+
+func deliver(ctx context.Context, db *DB, plan slotPlan) {
+	sendWeatherToUsers(ctx, db, "утро", plan.Morning)
+	sendAirQualityToUsers(ctx, db, plan.Air)
+	sendWeatherToUsers(ctx, db, "вечер", plan.Evening)
+	sendWeatherToUsers(ctx, db, "custom", plan.Custom)
+}
+
+// nextSlot returns the first full hour after now in local time.
+func nextSlot(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour()+1, 0, 0, 0, now.Location())
+}
+
+// sleepUntil waits for t and reports false if ctx was cancelled first.
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// startScheduler delivers the subscriptions once per slot: it prefetches
+// schedule.PrefetchLead ahead, then sends at the full hour. Like runEvery
+// it never interrupts a delivery that has started. The schedule is read
+// anew for every slot.
+func startScheduler(ctx context.Context, db *DB) {
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		runCtx := scheduledContext(context.WithoutCancel(ctx))
+		health.recordTick("subscriptions", time.Hour)
+		for slot := nextSlot(time.Now()); ; slot = nextSlot(time.Now()) {
+			schedule := currentSchedule()
+			if !sleepUntil(ctx, slot.Add(-schedule.PrefetchLead)) {
+				return
+			}
+			warmCtx, cancel := context.WithDeadline(runCtx, slot)
+			prefetch(warmCtx, db, planSlot(db, schedule, slot))
+			cancel()
+
+			if !sleepUntil(ctx, slot) {
+				return
+			}
+			// Planned again so users who subscribed in the meantime get
+			// their message too.
+			deliver(runCtx, db, planSlot(db, currentSchedule(), slot))
+			health.recordTick("subscriptions", time.Hour)
+		}
+	}()
+}
+
+// forEach calls fn for every item from up to n goroutines and waits for
+// all of them.
+func forEach[T any](items []T, n int, fn func(T)) {
+	ch := make(chan T)
+	var wg sync.WaitGroup
+	for i := 0; i < n && i < len(items); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range ch {
+				fn(item)
+			}
+		}()
+	}
+	for _, item := range items {
+		ch <- item
+	}
+	close(ch)
+	wg.Wait()
+}
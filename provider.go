@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errNotSupported is returned by providers for data they do not offer.
+var errNotSupported = errors.New("не поддерживается провайдером")
+
+// weatherProvider is a source of forecast data. Backends fill the common
+// forecastResponse model; optional data such as the minutely nowcast or
+// the UV index is reported as errNotSupported when a backend doesn't have
+// it.
+type weatherProvider interface {
+	Current(ctx context.Context, p place) (*currentView, error)
+	Forecast(ctx context.Context, p place) (*forecastResponse, error)
+	Minutely(ctx context.Context, lat, lon float64) ([]minutelyPrecip, error)
+	UVIndex(ctx context.Context, lat, lon float64) (float64, error)
+}
+
+// place identifies a location by city name or, when HasCoords is set, by
+// coordinates; City is then only used for display.
+type place struct {
+	City      string
+	Lat, Lon  float64
+	HasCoords bool
+}
+
+func cityPlace(city string) place {
+	return place{City: city}
+}
+
+// query returns the OpenWeatherMap query parameters selecting the place.
+func (p place) query() string {
+	if p.HasCoords {
+		return fmt.Sprintf("lat=%f&lon=%f", p.Lat, p.Lon)
+	}
+	return "q=" + url.QueryEscape(p.City)
+}
+
+// key identifies the place in caches.
+func (p place) key() string {
+	if p.HasCoords {
+		return fmt.Sprintf("%.2f,%.2f", p.Lat, p.Lon)
+	}
+	return strings.ToLower(strings.TrimSpace(p.City))
+}
+
+// minutelyPrecip is one minute of a precipitation nowcast, in mm/h.
+type minutelyPrecip struct {
+	Time          time.Time
+	Precipitation float64
+}
+
+// provider and airProvider are set up by configureProviders at startup.
+var provider weatherProvider
+
+func configureProviders(cfg WeatherConfig, ttl CacheConfig) {
+	owm := &owmProvider{
+		oneCall: cfg.OneCall,
+		client:  newWeatherClient(cfg.Timeout),
+		quota:   newQuotaManager(cfg.keys(), cfg.Quota),
+	}
+	provider = newCachedProvider(owm, ttl)
+	airProvider = newCachedAirQuality(owm, ttl.AirQuality)
+}
+
+// reconfigureProviders applies the weather settings /reload may change:
+// cache lifetimes, API keys and quota limits. Cached data and the calls
+// already counted on the keys are kept.
+func reconfigureProviders(cfg WeatherConfig, ttl CacheConfig) {
+	if p, ok := provider.(*cachedProvider); ok {
+		p.setTTLs(ttl)
+		if owm, ok := p.next.(*owmProvider); ok {
+			owm.quota.configure(cfg.keys(), cfg.Quota)
+		}
+	}
+	if a, ok := airProvider.(*cachedAirQuality); ok {
+		a.cache.setTTL(ttl.AirQuality)
+	}
+}
+
+// owmProvider talks to OpenWeatherMap. The minutely nowcast and the UV
+// index need the One Call 3.0 subscription and are only requested when
+// oneCall is set.
+type owmProvider struct {
+	oneCall bool
+	client  *http.Client
+	quota   *quotaManager
+}
+
+func (p *owmProvider) Current(ctx context.Context, pl place) (*currentView, error) {
+	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/weather?%s&units=metric&lang=ru", pl.query())
+
+	var data struct {
+		Main struct {
+			Temp float64 `json:"temp"`
+		} `json:"main"`
+		Weather []struct {
+			ID          int    `json:"id"`
+			Description string `json:"description"`
+			Icon        string `json:"icon"`
+		} `json:"weather"`
+		Coord struct {
+			Lat float64 `json:"lat"`
+			Lon float64 `json:"lon"`
+		} `json:"coord"`
+		Name string `json:"name"`
+	}
+
+	if err := p.get(ctx, "weather", url, &data); err != nil {
+		return nil, err
+	}
+
+	if len(data.Weather) == 0 {
+		return nil, fmt.Errorf("нет данных о погоде")
+	}
+
+	v := &currentView{
+		City:        data.Name,
+		Temp:        data.Main.Temp,
+		Description: data.Weather[0].Description,
+		Lat:         data.Coord.Lat,
+		Lon:         data.Coord.Lon,
+	}
+	v.Condition, v.Day = owmCondition(data.Weather[0].ID, data.Weather[0].Icon)
+	return v, nil
+}
+
+func (p *owmProvider) Forecast(ctx context.Context, pl place) (*forecastResponse, error) {
+	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/forecast?%s&units=metric&lang=ru", pl.query())
+
+	var data forecastResponse
+	if err := p.get(ctx, "forecast", url, &data); err != nil {
+		return nil, err
+	}
+
+	if len(data.List) < 1 {
+		return nil, fmt.Errorf("нет данных прогноза")
+	}
+
+	for i := range data.List {
+		e := &data.List[i]
+		if len(e.Weather) > 0 {
+			e.Condition, e.Day = owmCondition(e.Weather[0].ID, e.Weather[0].Icon)
+		}
+	}
+
+	return &data, nil
+}
+
+func (p *owmProvider) Minutely(ctx context.Context, lat, lon float64) ([]minutelyPrecip, error) {
+	if !p.oneCall {
+		return nil, errNotSupported
+	}
+	url := fmt.Sprintf("https://api.openweathermap.org/data/3.0/onecall?lat=%f&lon=%f&exclude=current,hourly,daily,alerts&units=metric", lat, lon)
+
+	var data struct {
+		Minutely []struct {
+			Dt            int64   `json:"dt"`
+			Precipitation float64 `json:"precipitation"`
+		} `json:"minutely"`
+	}
+
+	// Keys without the One Call subscription are refused with 401.
+	if err := p.get(ctx, "onecall", url, &data); errors.Is(err, errUnauthorized) {
+		return nil, errNotSupported
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(data.Minutely) == 0 {
+		return nil, errNotSupported
+	}
+
+	result := make([]minutelyPrecip, 0, len(data.Minutely))
+	for _, m := range data.Minutely {
+		result = append(result, minutelyPrecip{Time: time.Unix(m.Dt, 0), Precipitation: m.Precipitation})
+	}
+	return result, nil
+}
+
+func (p *owmProvider) UVIndex(ctx context.Context, lat, lon float64) (float64, error) {
+	if !p.oneCall {
+		return 0, errNotSupported
+	}
+	url := fmt.Sprintf("https://api.openweathermap.org/data/3.0/onecall?lat=%f&lon=%f&exclude=minutely,hourly,daily,alerts&units=metric", lat, lon)
+
+	var data struct {
+		Current *struct {
+			UVI float64 `json:"uvi"`
+		} `json:"current"`
+	}
+
+	if err := p.get(ctx, "onecall", url, &data); errors.Is(err, errUnauthorized) {
+		return 0, errNotSupported
+	} else if err != nil {
+		return 0, err
+	}
+
+	if data.Current == nil {
+		return 0, errNotSupported
+	}
+	return data.Current.UVI, nil
+}
+
+type cacheEntry[V any] struct {
+	value   V
+	expires time.Time
+}
+
+// ttlCache is a small concurrency-safe map whose entries expire after ttl.
+// Lookups are counted in the metrics under name.
+type ttlCache[V any] struct {
+	name    string
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]cacheEntry[V]
+}
+
+func newTTLCache[V any](name string, ttl time.Duration) *ttlCache[V] {
+	return &ttlCache[V]{name: name, ttl: ttl, entries: make(map[string]cacheEntry[V])}
+}
+
+func (c *ttlCache[V]) Get(key string) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || time.Now().After(e.expires) {
+		cacheRequestsTotal.WithLabelValues(c.name, "miss").Inc()
+		var zero V
+		return zero, false
+	}
+	cacheRequestsTotal.WithLabelValues(c.name, "hit").Inc()
+	return e.value, true
+}
+
+// setTTL changes the lifetime of entries stored from now on.
+func (c *ttlCache[V]) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	c.ttl = ttl
+	c.mu.Unlock()
+}
+
+func (c *ttlCache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for k, e := range c.entries {
+		if now.After(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = cacheEntry[V]{value: value, expires: now.Add(c.ttl)}
+}
+
+// unsupportedRecheck is how long cachedProvider answers errNotSupported
+// for optional data before asking the backend again, e.g. after the One
+// Call subscription was refused.
+const unsupportedRecheck = time.Hour
+
+// cachedProvider keeps recent responses of another provider so that the
+// schedulers and interactive requests for the same place share one call.
+type cachedProvider struct {
+	next     weatherProvider
+	current  *ttlCache[*currentView]
+	forecast *ttlCache[*forecastResponse]
+	minutely *ttlCache[[]minutelyPrecip]
+	uv       *ttlCache[float64]
+
+	mu          sync.Mutex
+	unsupported map[string]time.Time // data kind -> when to ask again
+}
+
+func newCachedProvider(next weatherProvider, ttl CacheConfig) *cachedProvider {
+	return &cachedProvider{
+		next:     next,
+		current:  newTTLCache[*currentView]("current", ttl.Current),
+		forecast: newTTLCache[*forecastResponse]("forecast", ttl.Forecast),
+		minutely: newTTLCache[[]minutelyPrecip]("minutely", ttl.Minutely),
+		uv:       newTTLCache[float64]("uv", ttl.UV),
+
+		unsupported: make(map[string]time.Time),
+	}
+}
+
+// isUnsupported reports whether the backend recently said it doesn't
+// offer kind; markUnsupported notes err if it says so.
+func (p *cachedProvider) isUnsupported(kind string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return time.Now().Before(p.unsupported[kind])
+}
+
+func (p *cachedProvider) markUnsupported(kind string, err error) {
+	if !errors.Is(err, errNotSupported) {
+		return
+	}
+	p.mu.Lock()
+	p.unsupported[kind] = time.Now().Add(unsupportedRecheck)
+	p.mu.Unlock()
+}
+
+func (p *cachedProvider) setTTLs(ttl CacheConfig) {
+	p.current.setTTL(ttl.Current)
+	p.forecast.setTTL(ttl.Forecast)
+	p.minutely.setTTL(ttl.Minutely)
+	p.uv.setTTL(ttl.UV)
+}
+
+// Current returns a copy, as callers fill in the air quality.
+func (p *cachedProvider) Current(ctx context.Context, pl place) (*currentView, error) {
+	key := pl.key()
+	v, ok := p.current.Get(key)
+	if !ok {
+		var err error
+		if v, err = p.next.Current(ctx, pl); err != nil {
+			return nil, err
+		}
+		p.current.Set(key, v)
+	}
+	c := *v
+	return &c, nil
+}
+
+func (p *cachedProvider) Forecast(ctx context.Context, pl place) (*forecastResponse, error) {
+	key := pl.key()
+	if data, ok := p.forecast.Get(key); ok {
+		return data, nil
+	}
+	data, err := p.next.Forecast(ctx, pl)
+	if err != nil {
+		return nil, err
+	}
+	p.forecast.Set(key, data)
+	return data, nil
+}
+
+func (p *cachedProvider) Minutely(ctx context.Context, lat, lon float64) ([]minutelyPrecip, error) {
+	key := fmt.Sprintf("%.2f,%.2f", lat, lon)
+	if data, ok := p.minutely.Get(key); ok {
+		return data, nil
+	}
+	if p.isUnsupported("minutely") {
+		return nil, errNotSupported
+	}
+	data, err := p.next.Minutely(ctx, lat, lon)
+	if err != nil {
+		p.markUnsupported("minutely", err)
+		return nil, err
+	}
+	p.minutely.Set(key, data)
+	return data, nil
+}
+
+func (p *cachedProvider) UVIndex(ctx context.Context, lat, lon float64) (float64, error) {
+	key := fmt.Sprintf("%.2f,%.2f", lat, lon)
+	if uvi, ok := p.uv.Get(key); ok {
+		return uvi, nil
+	}
+	if p.isUnsupported("uv") {
+		return 0, errNotSupported
+	}
+	uvi, err := p.next.UVIndex(ctx, lat, lon)
+	if err != nil {
+		p.markUnsupported("uv", err)
+		return 0, err
+	}
+	p.uv.Set(key, uvi)
+	return uvi, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// interactiveShare is the part of every limit kept for users asking in the
+// chat. Scheduled fetches stop short of it, so a big morning delivery
+// can't leave the menus without answers.
+const interactiveShare = 0.2
+
+// keyBlockRate and keyBlockAuth are how long a key is left alone after
+// the API refused it for the rate limit or as invalid.
+const (
+	keyBlockRate = time.Minute
+	keyBlockAuth = time.Hour
+)
+
+type priorityKey struct{}
+
+// scheduledContext marks requests made by the background jobs; requests
+// without the mark are interactive.
+func scheduledContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, priorityKey{}, true)
+}
+
+func isScheduled(ctx context.Context) bool {
+	scheduled, _ := ctx.Value(priorityKey{}).(bool)
+	return scheduled
+}
+
+func priorityLabel(scheduled bool) string {
+	if scheduled {
+		return "scheduled"
+	}
+	return "interactive"
+}
+
+// keyUsage counts the calls made with one API key in the current minute
+// and the current UTC day, the windows OpenWeatherMap counts in. One Call
+// calls are also counted on their own: that subscription has a daily
+// allowance of its own.
+type keyUsage struct {
+	key          string
+	minuteStart  time.Time
+	minuteCalls  int
+	day          time.Time
+	dayCalls     int
+	oneCallCalls int
+	blockedUntil time.Time
+}
+
+func (u *keyUsage) roll(now time.Time) {
+	if minute := now.Truncate(time.Minute); !minute.Equal(u.minuteStart) {
+		u.minuteStart, u.minuteCalls = minute, 0
+	}
+	if day := now.UTC().Truncate(24 * time.Hour); !day.Equal(u.day) {
+		u.day, u.dayCalls, u.oneCallCalls = day, 0, 0
+	}
+}
+
+// quotaManager hands out API keys within the per-key limits. Keys are
+// rotated by taking the least used one today. Interactive requests get a
+// key or fail at once with errRateLimited; scheduled ones are paced
+// evenly over the minute and wait for the next window when it is full.
+type quotaManager struct {
+	mu                sync.Mutex
+	perMinute, perDay int // per key; 0 is unlimited
+	oneCallPerDay     int // per key, One Call calls only; 0 is unlimited
+	keys              []*keyUsage
+	spacing           time.Duration
+	nextScheduled     time.Time
+}
+
+func newQuotaManager(keys []string, cfg QuotaConfig) *quotaManager {
+	q := &quotaManager{}
+	q.configure(keys, cfg)
+	return q
+}
+
+// configure sets the keys and limits. Keys that stay keep their usage and
+// blocks, so a reload doesn't hand out the day's quota twice.
+func (q *quotaManager) configure(keys []string, cfg QuotaConfig) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	usage := make(map[string]*keyUsage, len(q.keys))
+	for _, u := range q.keys {
+		usage[u.key] = u
+	}
+	q.keys = nil
+	for _, k := range keys {
+		u, ok := usage[k]
+		if !ok {
+			u = &keyUsage{key: k}
+		}
+		q.keys = append(q.keys, u)
+	}
+	q.perMinute, q.perDay, q.oneCallPerDay = cfg.PerMinute, cfg.PerDay, cfg.OneCallPerDay
+	q.spacing = 0
+	if n := q.scheduledLimit(cfg.PerMinute) * len(keys); n > 0 {
+		q.spacing = time.Minute / time.Duration(n)
+	}
+}
+
+// scheduledLimit is the part of limit scheduled requests may use.
+func (q *quotaManager) scheduledLimit(limit int) int {
+	if limit == 0 {
+		return 0
+	}
+	n := int(float64(limit) * (1 - interactiveShare))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (q *quotaManager) limits(scheduled bool) (perMinute, perDay, oneCallPerDay int) {
+	if scheduled {
+		return q.scheduledLimit(q.perMinute), q.scheduledLimit(q.perDay), q.scheduledLimit(q.oneCallPerDay)
+	}
+	return q.perMinute, q.perDay, q.oneCallPerDay
+}
+
+// pick returns the usable key with the fewest calls today. wait tells
+// when to try again if there is none: the next minute, or zero when only
+// the daily limits or blocks are left, which are not worth waiting for.
+func (q *quotaManager) pick(now time.Time, scheduled, oneCall bool) (best *keyUsage, wait time.Duration) {
+	perMinute, perDay, oneCallPerDay := q.limits(scheduled)
+	for _, u := range q.keys {
+		u.roll(now)
+		if now.Before(u.blockedUntil) || (perDay > 0 && u.dayCalls >= perDay) {
+			continue
+		}
+		if oneCall && oneCallPerDay > 0 && u.oneCallCalls >= oneCallPerDay {
+			continue
+		}
+		if perMinute > 0 && u.minuteCalls >= perMinute {
+			if w := u.minuteStart.Add(time.Minute).Sub(now); wait == 0 || w < wait {
+				wait = w
+			}
+			continue
+		}
+		if best == nil || u.dayCalls < best.dayCalls {
+			best = u
+		}
+	}
+	return best, wait
+}
+
+// acquire returns the key for the next call to endpoint and counts the
+// call.
+func (q *quotaManager) acquire(ctx context.Context, endpoint string) (string, error) {
+	scheduled := isScheduled(ctx)
+	for {
+		key, wait := q.take(time.Now(), scheduled, endpoint == "onecall")
+		if key != "" {
+			return key, nil
+		}
+		if !scheduled || wait == 0 {
+			quotaRejectionsTotal.WithLabelValues(priorityLabel(scheduled)).Inc()
+			return "", errRateLimited
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// take counts a call on the best key. Without a key it returns how long
+// to wait; scheduled calls also wait for their turn in the pacing.
+func (q *quotaManager) take(now time.Time, scheduled, oneCall bool) (string, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if scheduled && now.Before(q.nextScheduled) {
+		return "", q.nextScheduled.Sub(now)
+	}
+	u, wait := q.pick(now, scheduled, oneCall)
+	if u == nil {
+		return "", wait
+	}
+	u.minuteCalls++
+	u.dayCalls++
+	if oneCall {
+		u.oneCallCalls++
+	}
+	if scheduled {
+		q.nextScheduled = now.Add(q.spacing)
+	}
+	return u.key, 0
+}
+
+// report takes note of a refused call: the key rests for a minute after
+// a 429 and for an hour when it is rejected as invalid.
+func (q *quotaManager) report(key string, status int) {
+	var block time.Duration
+	switch status {
+	case 429:
+		block = keyBlockRate
+	case 401:
+		block = keyBlockAuth
+	default:
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, u := range q.keys {
+		if u.key == key {
+			u.blockedUntil = time.Now().Add(block)
+		}
+	}
+	slog.Warn("Ключ API погоды временно не используется", "provider", "openweathermap",
+		"key", maskKey(key), "status", status, "for", block)
+}
+
+// maskKey shortens a key to its last characters for the log.
+func maskKey(key string) string {
+	if len(key) <= 4 {
+		return "…"
+	}
+	return "…" + key[len(key)-4:]
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var quotaStart = time.Date(2024, 5, 13, 10, 0, 0, 0, time.UTC)
+
+// quotaCall is one take on the quota and what it should give.
+type quotaCall struct {
+	at        time.Duration // after quotaStart
+	scheduled bool
+	oneCall   bool
+	key       string // "" when the call is refused
+	wait      time.Duration
+}
+
+func runCalls(t *testing.T, q *quotaManager, calls []quotaCall) {
+	t.Helper()
+	for i, c := range calls {
+		key, wait := q.take(quotaStart.Add(c.at), c.scheduled, c.oneCall)
+		if key != c.key || wait != c.wait {
+			t.Errorf("call %d at +%v: got key %q, wait %v; want %q, %v", i, c.at, key, wait, c.key, c.wait)
+		}
+	}
+}
+
+func TestQuotaTake(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		keys  []string
+		cfg   QuotaConfig
+		calls []quotaCall
+	}{
+		{
+			name: "rotation",
+			keys: []string{"a", "b"},
+			calls: []quotaCall{
+				{key: "a"}, {key: "b"}, {key: "a"}, {key: "b"},
+			},
+		},
+		{
+			name: "minute limit",
+			keys: []string{"a"},
+			cfg:  QuotaConfig{PerMinute: 2},
+			calls: []quotaCall{
+				{key: "a"},
+				{at: 10 * time.Second, key: "a"},
+				{at: 20 * time.Second, wait: 40 * time.Second},
+				{at: time.Minute, key: "a"},
+			},
+		},
+		{
+			name: "pacing",
+			keys: []string{"a"},
+			// 80% of 10 a minute are 8 scheduled calls, 7.5s apart.
+			cfg: QuotaConfig{PerMinute: 10},
+			calls: []quotaCall{
+				{scheduled: true, key: "a"},
+				{at: time.Second, scheduled: true, wait: 6500 * time.Millisecond},
+				{at: time.Second, key: "a"},
+				{at: 7500 * time.Millisecond, scheduled: true, key: "a"},
+			},
+		},
+		{
+			name: "pacing over two keys",
+			keys: []string{"a", "b"},
+			cfg:  QuotaConfig{PerMinute: 10},
+			calls: []quotaCall{
+				{scheduled: true, key: "a"},
+				{at: 3750 * time.Millisecond, scheduled: true, key: "b"},
+			},
+		},
+		{
+			name: "daily rollover",
+			keys: []string{"a"},
+			cfg:  QuotaConfig{PerDay: 2},
+			calls: []quotaCall{
+				{key: "a"},
+				{at: time.Hour, key: "a"},
+				{at: 2 * time.Hour},
+				{at: 14 * time.Hour, key: "a"},
+			},
+		},
+		{
+			name: "interactive share",
+			keys: []string{"a"},
+			cfg:  QuotaConfig{PerDay: 5},
+			calls: []quotaCall{
+				{scheduled: true, key: "a"},
+				{at: time.Minute, scheduled: true, key: "a"},
+				{at: 2 * time.Minute, scheduled: true, key: "a"},
+				{at: 3 * time.Minute, scheduled: true, key: "a"},
+				{at: 4 * time.Minute, scheduled: true},
+				{at: 4 * time.Minute, key: "a"},
+			},
+		},
+		{
+			name: "one call budget",
+			keys: []string{"a"},
+			cfg:  QuotaConfig{OneCallPerDay: 1},
+			calls: []quotaCall{
+				{oneCall: true, key: "a"},
+				{oneCall: true},
+				{key: "a"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			runCalls(t, newQuotaManager(tc.keys, tc.cfg), tc.calls)
+		})
+	}
+}
+
+func TestQuotaBlockedKey(t *testing.T) {
+	q := newQuotaManager([]string{"a", "b"}, QuotaConfig{})
+	q.report("a", 429)
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if key, _ := q.take(now, false, false); key != "b" {
+			t.Fatalf("got key %q while a is blocked, want b", key)
+		}
+	}
+	if key, _ := q.take(now.Add(keyBlockRate+time.Second), false, false); key != "a" {
+		t.Errorf("got key %q after the block, want a", key)
+	}
+}
+
+// TestQuotaReloadKeepsUsage checks that /reload doesn't hand out the day's
+// quota of a key twice, while new keys start fresh.
+func TestQuotaReloadKeepsUsage(t *testing.T) {
+	cfg := QuotaConfig{PerDay: 2}
+	q := newQuotaManager([]string{"a"}, cfg)
+	runCalls(t, q, []quotaCall{{key: "a"}, {key: "a"}, {}})
+
+	q.configure([]string{"a", "c"}, cfg)
+	runCalls(t, q, []quotaCall{{key: "c"}, {key: "c"}, {}})
+
+	q.configure([]string{"a"}, QuotaConfig{PerDay: 3})
+	runCalls(t, q, []quotaCall{{key: "a"}, {}})
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Weather requests a user can make from the menus. They double as the
+// payload of the retry button, so keep them short.
+const (
+	requestCurrent   = "current"
+	requestAstro     = "astro"
+	requestAir       = "air"
+	requestTomorrow  = "tomorrow"
+	requestWeekly    = "weekly"
+	requestChartDay  = "chart_day"
+	requestChartWeek = "chart_week"
+	requestHourly    = "hourly:" // followed by the number of hours
+)
+
+const retryPrefix = "retry:"
+
+// staleReplyMaxAge is how old a saved answer may be and still be shown
+// while the weather API refuses requests over the rate limit.
+const staleReplyMaxAge = 6 * time.Hour
+
+// weatherReply is the answer to a request: a message or a chart.
+type weatherReply struct {
+	msg formatted
+	png []byte
+	at  time.Time
+}
+
+// lastReplies keeps the last good answer per request and city until it is
+// too old to stand in for a fresh one.
+var lastReplies = newTTLCache[weatherReply]("replies", staleReplyMaxAge)
+
+func replyKey(req, city string) string {
+	return req + "|" + strings.ToLower(strings.TrimSpace(city))
+}
+
+func fetchReply(ctx context.Context, db *DB, chatID int64, city, req string) (weatherReply, error) {
+	var (
+		msg formatted
+		err error
+	)
+	switch req {
+	case requestCurrent:
+		msg, err = getWeather(ctx, city)
+	case requestAstro:
+		msg, err = getAstronomy(ctx, city)
+	case requestAir:
+		msg, err = getAirQuality(ctx, city)
+	case requestTomorrow:
+		msg, err = getTomorrowForecast(ctx, city)
+	case requestWeekly:
+		msg, err = getWeeklyForecast(ctx, city)
+	case requestChartDay, requestChartWeek:
+		kind := strings.TrimPrefix(req, "chart_")
+		png, err := getForecastChart(ctx, city, kind, GetUserLanguage(db, chatID))
+		return weatherReply{png: png}, err
+	default:
+		hours, perr := strconv.Atoi(strings.TrimPrefix(req, requestHourly))
+		if !strings.HasPrefix(req, requestHourly) || perr != nil {
+			return weatherReply{}, fmt.Errorf("неизвестный запрос %q", req)
+		}
+		msg, err = getHourlyForecast(ctx, city, hours)
+	}
+	return weatherReply{msg: msg}, err
+}
+
+// inBackground runs fn outside the update loop, so a slow weather request
+// or chart doesn't hold up the other chats. Shutdown waits for it like for
+// the other jobs, and a request that has started is not cancelled.
+func inBackground(ctx context.Context, fn func(ctx context.Context)) {
+	jobs.Add(1)
+	go func() {
+		defer jobs.Done()
+		fn(context.WithoutCancel(ctx))
+	}()
+}
+
+// serveWeather answers a menu request for the user's city in the
+// background. Failures are explained instead of sending an empty message:
+// an unknown city leads to the city menu, a rate limit is covered with the
+// last saved answer when there is one, and other errors offer a retry
+// button.
+func serveWeather(ctx context.Context, db *DB, chatID int64, req string) {
+	city := GetUserCity(db, chatID)
+	if city == "" {
+		post(tgbotapi.NewMessage(chatID, "Сначала задайте город!"))
+		return
+	}
+	inBackground(ctx, func(ctx context.Context) {
+		answerWeather(ctx, db, chatID, city, req)
+	})
+}
+
+func answerWeather(ctx context.Context, db *DB, chatID int64, city, req string) {
+	key := replyKey(req, city)
+	reply, err := fetchReply(ctx, db, chatID, city, req)
+	if err == nil {
+		reply.at = time.Now()
+		lastReplies.Set(key, reply)
+		sendReply(chatID, reply, "")
+		return
+	}
+	slog.Warn("Ошибка ответа на запрос погоды", "chat_id", chatID, "city", city, "request", req, "err", err)
+
+	switch {
+	case errors.Is(err, errCityNotFound):
+		post(tgbotapi.NewMessage(chatID, fmt.Sprintf("Город «%s» не найден. Задайте город заново.", city)))
+		showCitySelectionMenu(chatID)
+		return
+	case errors.Is(err, errRateLimited):
+		if saved, ok := lastReplies.Get(key); ok {
+			sendReply(chatID, saved, fmt.Sprintf("⚠️ Данные на %s: сервис погоды перегружен, показываю сохранённый прогноз.",
+				saved.at.Format("15:04")))
+			return
+		}
+	}
+
+	msg := tgbotapi.NewMessage(chatID, userMessage(err))
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("🔄 Повторить", retryPrefix+req),
+	))
+	post(msg)
+}
+
+// sendReply sends the answer; a non-empty note is put in front of it.
+func sendReply(chatID int64, reply weatherReply, note string) {
+	if reply.png != nil {
+		photo := tgbotapi.NewPhoto(chatID, tgbotapi.FileBytes{Name: "forecast.png", Bytes: reply.png})
+		photo.Caption = note
+		post(photo)
+		return
+	}
+	msg := reply.msg.message(chatID)
+	if note != "" {
+		switch msg.ParseMode {
+		case tgbotapi.ModeHTML:
+			note = html.EscapeString(note)
+		case tgbotapi.ModeMarkdownV2:
+			note = tgbotapi.EscapeText(tgbotapi.ModeMarkdownV2, note)
+		}
+		msg.Text = note + "\n\n" + msg.Text
+	}
+	post(msg)
+}
+
+// handleCallback answers inline button presses; the only ones the bot
+// sends are retry buttons. The answer bypasses the outbox: it is not a
+// message and doesn't count against the chat limits.
+func handleCallback(ctx context.Context, db *DB, cb *tgbotapi.CallbackQuery) {
+	if _, err := bot.Request(tgbotapi.NewCallback(cb.ID, "")); err != nil {
+		slog.Warn("Ошибка ответа на нажатие кнопки", "chat_id", cb.From.ID, "err", err)
+	}
+	req, ok := strings.CutPrefix(cb.Data, retryPrefix)
+	if !ok || cb.Message == nil {
+		return
+	}
+	serveWeather(ctx, db, cb.Message.Chat.ID, req)
+}
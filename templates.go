@@ -0,0 +1,282 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Default message templates. Operators can override any of them, or add
+// per-channel variants, by putting files into TEMPLATES_DIR:
+//
+//	current.html.tmpl                   replaces the default "current"
+//	current.md.tmpl                     same, written in MarkdownV2
+//	channels/<channel>/weekly.html.tmpl "weekly" for one channel only
+//
+//go:embed templates/*.tmpl
+var defaultTemplates embed.FS
+
+// Template formats and the Telegram parse modes they map to.
+const (
+	formatHTML     = "html"
+	formatMarkdown = "md"
+)
+
+var parseModes = map[string]string{
+	formatHTML:     tgbotapi.ModeHTML,
+	formatMarkdown: tgbotapi.ModeMarkdownV2,
+}
+
+// formatted is a rendered message together with the parse mode Telegram
+// needs to display it.
+type formatted struct {
+	Text      string
+	ParseMode string
+}
+
+func (f formatted) message(chatID int64) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(chatID, f.Text)
+	msg.ParseMode = f.ParseMode
+	return msg
+}
+
+func (f formatted) channelMessage(channel string) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessageToChannel(channel, f.Text)
+	msg.ParseMode = f.ParseMode
+	return msg
+}
+
+// templateSet holds one template tree per format, so {{template}} calls
+// resolve to templates written in the same markup. formats records which
+// tree each name is rendered from; an operator file wins over the default.
+type templateSet struct {
+	trees   map[string]*template.Template
+	formats map[string]string
+}
+
+var (
+	templatesMu sync.RWMutex
+	templates   *templateSet
+)
+
+// loadTemplates parses the built-in templates and the operator overrides
+// in dir (may be empty) and makes them current.
+func loadTemplates(dir string) error {
+	set := &templateSet{
+		trees:   make(map[string]*template.Template),
+		formats: make(map[string]string),
+	}
+	for format := range parseModes {
+		set.trees[format] = template.New(format).Funcs(templateFuncs(format))
+	}
+
+	if err := set.addFS(defaultTemplates, "templates"); err != nil {
+		return err
+	}
+	if dir != "" {
+		if err := set.addFS(os.DirFS(dir), "."); err != nil {
+			return err
+		}
+	}
+
+	templatesMu.Lock()
+	templates = set
+	templatesMu.Unlock()
+	return nil
+}
+
+// addFS parses every *.html.tmpl and *.md.tmpl below root. The template
+// name is the path relative to root without extensions, so channel
+// variants are named "channels/<channel>/<name>".
+func (s *templateSet) addFS(fsys fs.FS, root string) error {
+	return fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		base, ok := strings.CutSuffix(p, ".tmpl")
+		if !ok {
+			return nil
+		}
+		format := strings.TrimPrefix(path.Ext(base), ".")
+		tree, ok := s.trees[format]
+		if !ok {
+			return fmt.Errorf("шаблон %s: неизвестный формат %q", p, format)
+		}
+		name := strings.TrimSuffix(base, path.Ext(base))
+		if rel, err := relPath(root, name); err == nil {
+			name = rel
+		}
+
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+		if _, err := tree.New(name).Parse(string(content)); err != nil {
+			return fmt.Errorf("шаблон %s: %w", p, err)
+		}
+		s.formats[name] = format
+		return nil
+	})
+}
+
+func relPath(root, p string) (string, error) {
+	if root == "." {
+		return p, nil
+	}
+	rel, ok := strings.CutPrefix(p, root+"/")
+	if !ok {
+		return "", fmt.Errorf("%s is outside %s", p, root)
+	}
+	return rel, nil
+}
+
+func (s *templateSet) render(name string, data any) (formatted, error) {
+	format, ok := s.formats[name]
+	if !ok {
+		return formatted{}, fmt.Errorf("шаблон %q не найден", name)
+	}
+	var b strings.Builder
+	if err := s.trees[format].ExecuteTemplate(&b, name, data); err != nil {
+		return formatted{}, err
+	}
+	return formatted{Text: strings.TrimSpace(b.String()), ParseMode: parseModes[format]}, nil
+}
+
+// renderTemplate renders a message for a user chat.
+func renderTemplate(name string, data any) (formatted, error) {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	return templates.render(name, data)
+}
+
+// renderChannelTemplate prefers the channel's own variant of the template
+// and falls back to the shared one.
+func renderChannelTemplate(channel, name string, data any) (formatted, error) {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	own := "channels/" + strings.TrimPrefix(channel, "@") + "/" + name
+	if _, ok := templates.formats[own]; ok {
+		return templates.render(own, data)
+	}
+	return templates.render(name, data)
+}
+
+// channelTemplateExists reports whether renderChannelTemplate would find
+// the template name for the channel.
+func channelTemplateExists(channel, name string) bool {
+	templatesMu.RLock()
+	defer templatesMu.RUnlock()
+	_, own := templates.formats["channels/"+strings.TrimPrefix(channel, "@")+"/"+name]
+	_, shared := templates.formats[name]
+	return own || shared
+}
+
+// templateFuncs returns the helpers available in templates. Every helper
+// that produces text escapes it for the format, so templates only need
+// esc for raw strings coming from data.
+func templateFuncs(format string) template.FuncMap {
+	esc := func(s string) string {
+		return tgbotapi.EscapeText(parseModes[format], s)
+	}
+	sprintf := func(f string, args ...any) string {
+		return esc(fmt.Sprintf(f, args...))
+	}
+
+	return template.FuncMap{
+		"esc": esc,
+		"temp": func(t float64) string {
+			return sprintf("%.1f°C", t)
+		},
+		"tempInt": func(t float64) string {
+			return sprintf("%d°C", int(math.Round(t)))
+		},
+		"tempRange": func(min, max float64) string {
+			return sprintf("%d~%d°C", int(min), int(max))
+		},
+		"percent": func(p float64) string {
+			return sprintf("%d%%", int(p*100+0.5))
+		},
+		"mm": func(v float64) string {
+			return sprintf("%.1f мм", v)
+		},
+		"speed": func(v float64) string {
+			return sprintf("%.0f м/с", v)
+		},
+		"number": func(v float64, digits int) string {
+			return sprintf("%.*f", digits, v)
+		},
+		"clock": func(t time.Time) string {
+			return esc(t.Format("15:04"))
+		},
+		"date": func(t time.Time) string {
+			return sprintf("%d %s", t.Day(), monthRu(t.Month()))
+		},
+		"weekday": func(t time.Time) string {
+			return esc(weekdayRu(t.Weekday()))
+		},
+		"dateLong": func(t time.Time) string {
+			return sprintf("%d %s, %s", t.Day(), monthRu(t.Month()), weekdayRu(t.Weekday()))
+		},
+		"dayLength": func(d time.Duration) string {
+			return esc(formatDayLength(d))
+		},
+		"dayLengthChange": func(d time.Duration) string {
+			return esc(formatDayLengthChange(d))
+		},
+		"aqiLabel": func(aqi int) string {
+			return esc(aqiLabel(aqi))
+		},
+		"aqiEmoji": aqiEmoji,
+		"uvLabel": func(uvi float64) string {
+			return esc(uvLabel(uvi))
+		},
+		"moonPhase": func(age float64) string {
+			return esc(moonPhaseName(age))
+		},
+		"icon": func(c condition, day bool) string {
+			return c.Emoji(day)
+		},
+		"emoji": func(name string) string {
+			return templateEmoji[name]
+		},
+		"dict": func(kv ...any) (map[string]any, error) {
+			if len(kv)%2 != 0 {
+				return nil, fmt.Errorf("dict: нечётное число аргументов")
+			}
+			m := make(map[string]any, len(kv)/2)
+			for i := 0; i < len(kv); i += 2 {
+				key, ok := kv[i].(string)
+				if !ok {
+					return nil, fmt.Errorf("dict: ключ %v не строка", kv[i])
+				}
+				m[key] = kv[i+1]
+			}
+			return m, nil
+		},
+	}
+}
+
+// templateEmoji are named emoji for templates, so operator templates don't
+// have to paste the symbols.
+var templateEmoji = map[string]string{
+	"current":  "🌤",
+	"clock":    "⏰",
+	"hourly":   "⏱",
+	"calendar": "📅",
+	"rain":     "☔",
+	"wind":     "💨",
+	"day":      "🌞",
+	"night":    "🌙",
+	"sunrise":  "🌄",
+	"sunset":   "🌆",
+	"uv":       "🧴",
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	pollTimeout    = 60
+	pollRetryDelay = 3 * time.Second
+)
+
+// updateReceiver delivers updates until closed. Once Close returns nil
+// no more updates arrive and the channel is closed after the last one.
+type updateReceiver interface {
+	Close(ctx context.Context) error
+}
+
+// drainUpdates hands the updates still in the channel to handle. It stops
+// at the end of the channel, or once it is empty if the receiver could not
+// be closed cleanly.
+func drainUpdates(ctx context.Context, updates <-chan tgbotapi.Update, handle func(tgbotapi.Update)) {
+	var n int
+	defer func() {
+		if n > 0 {
+			slog.Info("Обработаны оставшиеся обновления", "count", n)
+		}
+	}()
+	for ctx.Err() == nil {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			handle(update)
+			n++
+		default:
+			return
+		}
+	}
+}
+
+// receiveUpdates starts the webhook when its URL is configured and long
+// polling otherwise.
+func receiveUpdates(cfg WebhookConfig) (<-chan tgbotapi.Update, updateReceiver, error) {
+	if cfg.URL != "" {
+		wh, err := startWebhook(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return wh.updates, wh, nil
+	}
+
+	// getUpdates is refused while a webhook is set.
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	api := *bot
+	api.Client = contextClient{ctx: ctx, next: bot.Client}
+	p := &poller{
+		api:     &api,
+		updates: make(chan tgbotapi.Update, bot.Buffer),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go p.run(ctx)
+	return p.updates, p, nil
+}
+
+// poller is long polling like bot.GetUpdatesChan, but it reports every
+// successful getUpdates to the health checks, so a stuck poll is noticed
+// even when nobody writes to the bot.
+type poller struct {
+	api     *tgbotapi.BotAPI
+	updates chan tgbotapi.Update
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// contextClient sends the requests with ctx, so closing the poller aborts
+// a long poll in flight instead of waiting up to pollTimeout for it.
+type contextClient struct {
+	ctx  context.Context
+	next tgbotapi.HTTPClient
+}
+
+func (c contextClient) Do(req *http.Request) (*http.Response, error) {
+	return c.next.Do(req.WithContext(c.ctx))
+}
+
+func (p *poller) run(ctx context.Context) {
+	defer close(p.done)
+	defer close(p.updates)
+
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = pollTimeout
+	for ctx.Err() == nil {
+		updates, err := p.api.GetUpdates(u)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			slog.Warn("Ошибка получения обновлений", "err", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollRetryDelay):
+			}
+			continue
+		}
+		health.recordUpdates()
+
+		for _, update := range updates {
+			if update.UpdateID < u.Offset {
+				continue
+			}
+			select {
+			case p.updates <- update:
+				u.Offset = update.UpdateID + 1
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Close stops polling and aborts the request in flight. The updates it
+// would have returned are not confirmed, so Telegram sends them again next
+// start. Those already in the channel were confirmed by that request and
+// have to be handled before exit.
+func (p *poller) Close(ctx context.Context) error {
+	p.cancel()
+	select {
+	case <-p.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -1,279 +1,424 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
-	"os"
+	"log/slog"
+	"math"
 	"sort"
 	"time"
 )
 
-func getWeather(city string) (string, error) {
-	key := os.Getenv("OPENWEATHER_TOKEN")
-	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/weather?q=%s&appid=%s&units=metric&lang=ru", city, key)
-
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var data struct {
-		Main struct {
-			Temp float64 `json:"temp"`
-		} `json:"main"`
-		Weather []struct {
-			Description string `json:"description"`
-		} `json:"weather"`
-		Name string `json:"name"`
-	}
+// currentView is the data of the current weather card.
+type currentView struct {
+	City        string
+	Temp        float64
+	Description string
+	Condition   condition
+	Day         bool
+	Lat, Lon    float64
+	Air         *airQuality
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return "", err
-	}
+// scheduledView is a subscription message: the current weather and,
+// if the user enabled it, the sun and moon section.
+type scheduledView struct {
+	Current *currentView
+	Astro   *astroView
+}
 
-	if len(data.Weather) == 0 {
-		return "", fmt.Errorf("нет данных о погоде")
-	}
+type channelCurrentView struct {
+	City    string
+	Current *currentView
+}
 
-	return fmt.Sprintf("🌤 В %s сейчас %.1f°C, %s", data.Name, data.Main.Temp, data.Weather[0].Description), nil
+type channelTomorrowView struct {
+	City     string
+	Tomorrow *tomorrowView
 }
 
-func getWeatherByCoordsAndCity(lat, lon float64) (string, string, error) {
-	key := os.Getenv("OPENWEATHER_TOKEN")
-	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/weather?lat=%f&lon=%f&appid=%s&units=metric&lang=ru", lat, lon, key)
+type channelWeeklyView struct {
+	City   string
+	Weekly *weeklyView
+}
 
-	resp, err := http.Get(url)
+// fetchCurrent returns the current weather at the place together with
+// its air quality, when available. Without fetchAir the air quality is
+// only taken from the cache: subscription deliveries pass false, since the
+// extra call per location would halve how many fit the paced prefetch.
+func fetchCurrent(ctx context.Context, pl place, fetchAir bool) (*currentView, error) {
+	v, err := provider.Current(ctx, pl)
 	if err != nil {
-		return "", "", err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
-	var data struct {
-		Main struct {
-			Temp float64 `json:"temp"`
-		} `json:"main"`
-		Weather []struct {
-			Description string `json:"description"`
-		} `json:"weather"`
-		Name string `json:"name"`
+	if !fetchAir {
+		v.Air = cachedAirQualityAt(v.Lat, v.Lon)
+		return v, nil
 	}
-
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return "", "", err
+	if aq, err := airProvider.AirQuality(ctx, v.Lat, v.Lon); err != nil {
+		slog.Warn("Ошибка получения качества воздуха", "city", v.City, "err", err)
+	} else {
+		v.Air = aq
 	}
+	return v, nil
+}
 
-	if len(data.Weather) == 0 {
-		return "", "", fmt.Errorf("нет данных о погоде")
+func getWeather(ctx context.Context, city string) (formatted, error) {
+	v, err := fetchCurrent(ctx, cityPlace(city), true)
+	if err != nil {
+		return formatted{}, err
 	}
-
-	forecast := fmt.Sprintf("🌤 В %s сейчас %.1f°C, %s", data.Name, data.Main.Temp, data.Weather[0].Description)
-	return forecast, data.Name, nil
+	return renderTemplate("current", v)
 }
 
-func getHourlyForecast(city string) (string, error) {
-	key := os.Getenv("OPENWEATHER_TOKEN")
-	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/forecast?q=%s&appid=%s&units=metric&lang=ru", city, key)
-
-	resp, err := http.Get(url)
+func getWeatherByCoordsAndCity(ctx context.Context, lat, lon float64) (formatted, string, error) {
+	v, err := fetchCurrent(ctx, place{Lat: lat, Lon: lon, HasCoords: true}, true)
 	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var data struct {
-		List []struct {
-			DtTxt  string `json:"dt_txt"`
-			Main   struct {
-				Temp float64 `json:"temp"`
-			} `json:"main"`
-			Weather []struct {
-				Description string `json:"description"`
-			} `json:"weather"`
-		} `json:"list"`
+		return formatted{}, "", err
 	}
-
-	err = json.NewDecoder(resp.Body).Decode(&data)
+	forecast, err := renderTemplate("current", v)
 	if err != nil {
-		return "", err
+		return formatted{}, "", err
 	}
+	return forecast, v.City, nil
+}
 
-	if len(data.List) < 1 {
-		return "", fmt.Errorf("нет данных прогноза")
-	}
+type forecastEntry struct {
+	Dt    int64  `json:"dt"`
+	DtTxt string `json:"dt_txt"`
+	Main  struct {
+		Temp float64 `json:"temp"`
+	} `json:"main"`
+	Weather []struct {
+		ID          int    `json:"id"`
+		Description string `json:"description"`
+		Icon        string `json:"icon"`
+	} `json:"weather"`
+	Wind struct {
+		Speed float64 `json:"speed"`
+		Gust  float64 `json:"gust"`
+	} `json:"wind"`
+	Rain struct {
+		Volume float64 `json:"3h"`
+	} `json:"rain"`
+	Snow struct {
+		Volume float64 `json:"3h"`
+	} `json:"snow"`
+	Pop float64 `json:"pop"`
+
+	// Condition and Day are the normalized category, filled by the
+	// provider that produced the entry.
+	Condition condition `json:"-"`
+	Day       bool      `json:"-"`
+}
 
-	entry := data.List[0]
+type forecastResponse struct {
+	List []forecastEntry `json:"list"`
+	City struct {
+		Name  string `json:"name"`
+		Coord struct {
+			Lat float64 `json:"lat"`
+			Lon float64 `json:"lon"`
+		} `json:"coord"`
+		Timezone int `json:"timezone"`
+	} `json:"city"`
+}
 
-	return fmt.Sprintf("⏰ Прогноз через час: %.1f°C, %s", entry.Main.Temp, entry.Weather[0].Description), nil
+// hourlyPoint is a single hour of the short-range forecast, interpolated
+// from the surrounding 3-hour entries.
+type hourlyPoint struct {
+	Time        time.Time
+	Temp        float64
+	Pop         float64
+	Description string
+	Condition   condition
+	Day         bool
 }
 
-func getTomorrowForecast(city string) (string, error) {
-	key := os.Getenv("OPENWEATHER_TOKEN")
-	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/forecast?q=%s&appid=%s&units=metric&lang=ru", city, key)
+var hourlyHorizons = []int{1, 3, 6, 12}
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var data struct {
-		List []struct {
-			DtTxt  string `json:"dt_txt"`
-			Main   struct {
-				Temp float64 `json:"temp"`
-			} `json:"main"`
-			Weather []struct {
-				Description string `json:"description"`
-			} `json:"weather"`
-		} `json:"list"`
-	}
+func fetchForecast(ctx context.Context, city string) (*forecastResponse, error) {
+	return provider.Forecast(ctx, cityPlace(city))
+}
 
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return "", err
-	}
+func (f *forecastResponse) location() *time.Location {
+	return time.FixedZone("", f.City.Timezone)
+}
 
-	loc := time.Now().Location()
-	tomorrow := time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+func (e forecastEntry) description() string {
+	if len(e.Weather) == 0 {
+		return ""
+	}
+	return e.Weather[0].Description
+}
 
-	var temps []float64
-	var descriptions []string
+// interpolateAt estimates the weather at t by linear interpolation between
+// the two 3-hour entries around it. Times before the first entry take the
+// first entry's values; times after the last one are not covered.
+func interpolateAt(list []forecastEntry, t time.Time) (hourlyPoint, bool) {
+	ts := t.Unix()
+	if len(list) == 0 || ts > list[len(list)-1].Dt {
+		return hourlyPoint{}, false
+	}
+	if ts <= list[0].Dt {
+		e := list[0]
+		return hourlyPoint{Time: t, Temp: e.Main.Temp, Pop: e.Pop, Description: e.description(), Condition: e.Condition, Day: e.Day}, true
+	}
 
-	for _, entry := range data.List {
-		if len(entry.DtTxt) < 10 {
+	for i := 1; i < len(list); i++ {
+		a, b := list[i-1], list[i]
+		if ts > b.Dt {
 			continue
 		}
-		date := entry.DtTxt[:10]
-		if date == tomorrow {
-			temps = append(temps, entry.Main.Temp)
-			if len(entry.Weather) > 0 {
-				descriptions = append(descriptions, entry.Weather[0].Description)
-			}
+		k := 0.0
+		if b.Dt > a.Dt {
+			k = float64(ts-a.Dt) / float64(b.Dt-a.Dt)
 		}
+		nearest := a
+		if k >= 0.5 {
+			nearest = b
+		}
+		return hourlyPoint{
+			Time:        t,
+			Temp:        a.Main.Temp + (b.Main.Temp-a.Main.Temp)*k,
+			Pop:         a.Pop + (b.Pop-a.Pop)*k,
+			Description: nearest.description(),
+			Condition:   nearest.Condition,
+			Day:         nearest.Day,
+		}, true
 	}
+	return hourlyPoint{}, false
+}
 
-	if len(temps) == 0 {
-		return "", fmt.Errorf("нет данных для прогноза на завтра")
-	}
-
-	minTemp, maxTemp := temps[0], temps[0]
-	for _, t := range temps {
-		if t < minTemp {
-			minTemp = t
-		}
-		if t > maxTemp {
-			maxTemp = t
+// nextHours returns forecast points for the next full hours in the city's
+// local time, starting with the first hour after now.
+func nextHours(data *forecastResponse, now time.Time, hours int) []hourlyPoint {
+	lt := now.In(data.location())
+	start := time.Date(lt.Year(), lt.Month(), lt.Day(), lt.Hour()+1, 0, 0, 0, lt.Location())
+
+	var points []hourlyPoint
+	for i := 0; i < hours; i++ {
+		p, ok := interpolateAt(data.List, start.Add(time.Duration(i)*time.Hour))
+		if !ok {
+			break
 		}
+		points = append(points, p)
 	}
+	return points
+}
 
-	desc := mostFrequent(descriptions)
+type hourlyView struct {
+	City   string
+	Points []hourlyPoint
+}
 
-	dt, err := time.ParseInLocation("2006-01-02", tomorrow, loc)
+func getHourlyForecast(ctx context.Context, city string, hours int) (formatted, error) {
+	data, err := fetchForecast(ctx, city)
 	if err != nil {
-		dt = time.Now().AddDate(0, 0, 1)
+		return formatted{}, err
 	}
 
-	weekdayStr := weekdayRu(dt.Weekday())
+	points := nextHours(data, time.Now(), hours)
+	if len(points) == 0 {
+		return formatted{}, fmt.Errorf("нет данных прогноза")
+	}
 
-	return fmt.Sprintf("📅 Завтра (%s): %d~%d°C, %s", weekdayStr, int(minTemp), int(maxTemp), desc), nil
+	return renderTemplate("hourly", hourlyView{City: data.City.Name, Points: points})
 }
 
-func getWeeklyForecast(city string) (string, error) {
-	key := os.Getenv("OPENWEATHER_TOKEN")
-	url := fmt.Sprintf("https://api.openweathermap.org/data/2.5/forecast?q=%s&appid=%s&units=metric&lang=ru", city, key)
+// forecastStep is the length of one entry of the OWM 5 day / 3 hour forecast.
+const forecastStep = 3 * time.Hour
 
-	resp, err := http.Get(url)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
-
-	var data struct {
-		List []struct {
-			DtTxt  string `json:"dt_txt"`
-			Main   struct {
-				Temp float64 `json:"temp"`
-			} `json:"main"`
-			Weather []struct {
-				Description string `json:"description"`
-			} `json:"weather"`
-		} `json:"list"`
-	}
+// Day part boundaries in local time: entries from dayStartHour up to
+// nightStartHour count as day, the rest of the date as night.
+const (
+	dayStartHour   = 9
+	nightStartHour = 21
+)
 
-	err = json.NewDecoder(resp.Body).Decode(&data)
-	if err != nil {
-		return "", err
+// precipWindow is a run of consecutive 3-hour entries with precipitation.
+type precipWindow struct {
+	Kind string
+	From time.Time
+	To   time.Time
+}
+
+// daySummary condenses the 3-hour entries of one local date.
+type daySummary struct {
+	Date        time.Time
+	Min, Max    float64
+	DayMax      float64
+	NightMin    float64
+	HasDay      bool
+	HasNight    bool
+	Description string
+	Condition   condition
+	Precip      float64
+	Pop         float64
+	GustMax     float64
+	Windows     []precipWindow
+}
+
+func (e forecastEntry) thunderstorm() bool {
+	return e.Condition == conditionThunderstorm
+}
+
+// precipKind names the kind of precipitation in the entry, or returns ""
+// when the entry is dry.
+func (e forecastEntry) precipKind() string {
+	switch {
+	case e.thunderstorm():
+		return "гроза"
+	case e.Snow.Volume > 0 && e.Snow.Volume >= e.Rain.Volume:
+		return "снег"
+	case e.Rain.Volume > 0:
+		return "дождь"
 	}
+	return ""
+}
 
-	type daySummary struct {
-		date         string
-		temps        []float64
-		descriptions []string
+func (e forecastEntry) gust() float64 {
+	if e.Wind.Gust > e.Wind.Speed {
+		return e.Wind.Gust
 	}
+	return e.Wind.Speed
+}
 
+// summarizeDays groups forecast entries by local date and returns the
+// summaries in chronological order.
+func summarizeDays(data *forecastResponse) []*daySummary {
+	loc := data.location()
 	dayMap := make(map[string]*daySummary)
+	descriptions := make(map[string][]string)
+	conditions := make(map[string][]condition)
+	var keys []string
 
 	for _, entry := range data.List {
-		dt, err := time.Parse("2006-01-02 15:04:05", entry.DtTxt)
-		if err != nil {
-			continue
-		}
-		dayKey := dt.Format("2006-01-02")
+		t := time.Unix(entry.Dt, 0).In(loc)
+		dayKey := t.Format("2006-01-02")
 
 		ds, exists := dayMap[dayKey]
 		if !exists {
-			ds = &daySummary{date: dayKey}
+			ds = &daySummary{
+				Date: time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc),
+				Min:  entry.Main.Temp,
+				Max:  entry.Main.Temp,
+			}
 			dayMap[dayKey] = ds
+			keys = append(keys, dayKey)
 		}
-		ds.temps = append(ds.temps, entry.Main.Temp)
-		if len(entry.Weather) > 0 {
-			ds.descriptions = append(ds.descriptions, entry.Weather[0].Description)
+
+		temp := entry.Main.Temp
+		ds.Min = math.Min(ds.Min, temp)
+		ds.Max = math.Max(ds.Max, temp)
+		if t.Hour() >= dayStartHour && t.Hour() < nightStartHour {
+			if !ds.HasDay || temp > ds.DayMax {
+				ds.DayMax = temp
+			}
+			ds.HasDay = true
+		} else {
+			if !ds.HasNight || temp < ds.NightMin {
+				ds.NightMin = temp
+			}
+			ds.HasNight = true
+		}
+
+		ds.Precip += entry.Rain.Volume + entry.Snow.Volume
+		ds.Pop = math.Max(ds.Pop, entry.Pop)
+		ds.GustMax = math.Max(ds.GustMax, entry.gust())
+
+		if kind := entry.precipKind(); kind != "" {
+			n := len(ds.Windows)
+			if n > 0 && ds.Windows[n-1].Kind == kind && ds.Windows[n-1].To.Equal(t) {
+				ds.Windows[n-1].To = t.Add(forecastStep)
+			} else {
+				ds.Windows = append(ds.Windows, precipWindow{Kind: kind, From: t, To: t.Add(forecastStep)})
+			}
 		}
-	}
 
-	keys := make([]string, 0, len(dayMap))
-	for k := range dayMap {
-		keys = append(keys, k)
+		if d := entry.description(); d != "" {
+			descriptions[dayKey] = append(descriptions[dayKey], d)
+		}
+		conditions[dayKey] = append(conditions[dayKey], entry.Condition)
 	}
+
 	sort.Strings(keys)
+	days := make([]*daySummary, 0, len(keys))
+	for _, k := range keys {
+		ds := dayMap[k]
+		ds.Description = mostFrequent(descriptions[k])
+		ds.Condition = mostFrequent(conditions[k])
+		days = append(days, ds)
+	}
+	return days
+}
 
-	result := "Прогноз на 7 дней:\n"
-	loc := time.Now().Location()
+func (w precipWindow) String() string {
+	to := w.To.Hour()
+	if to == 0 {
+		to = 24
+	}
+	return fmt.Sprintf("%s с %d до %d", w.Kind, w.From.Hour(), to)
+}
 
-	for _, day := range keys {
-		ds := dayMap[day]
-		minTemp, maxTemp := ds.temps[0], ds.temps[0]
-		for _, t := range ds.temps {
-			if t < minTemp {
-				minTemp = t
-			}
-			if t > maxTemp {
-				maxTemp = t
-			}
-		}
-		desc := mostFrequent(ds.descriptions)
+type tomorrowView struct {
+	Day *daySummary
+}
+
+type weeklyView struct {
+	City string
+	Days []*daySummary
+}
+
+func tomorrowData(ctx context.Context, p place) (*tomorrowView, error) {
+	data, err := provider.Forecast(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	tomorrow := time.Now().In(data.location()).AddDate(0, 0, 1).Format("2006-01-02")
 
-		dt, err := time.ParseInLocation("2006-01-02", ds.date, loc)
-		if err != nil {
-			dt = time.Now()
+	var ds *daySummary
+	for _, d := range summarizeDays(data) {
+		if d.Date.Format("2006-01-02") == tomorrow {
+			ds = d
 		}
+	}
+	if ds == nil {
+		return nil, fmt.Errorf("нет данных для прогноза на завтра")
+	}
 
-		formattedDate := fmt.Sprintf("%d %s, %s", dt.Day(), monthRu(dt.Month()), weekdayRu(dt.Weekday()))
+	return &tomorrowView{Day: ds}, nil
+}
 
-		result += fmt.Sprintf("📅 %s: %d~%d°C, %s\n", formattedDate, int(minTemp), int(maxTemp), desc)
+func getTomorrowForecast(ctx context.Context, city string) (formatted, error) {
+	v, err := tomorrowData(ctx, cityPlace(city))
+	if err != nil {
+		return formatted{}, err
 	}
+	return renderTemplate("tomorrow", v)
+}
 
-	return result, nil
+func weeklyData(ctx context.Context, p place) (*weeklyView, error) {
+	data, err := provider.Forecast(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return &weeklyView{City: data.City.Name, Days: summarizeDays(data)}, nil
+}
+
+func getWeeklyForecast(ctx context.Context, city string) (formatted, error) {
+	v, err := weeklyData(ctx, cityPlace(city))
+	if err != nil {
+		return formatted{}, err
+	}
+	return renderTemplate("weekly", v)
 }
 
-func mostFrequent(arr []string) string {
-	count := make(map[string]int)
+func mostFrequent[T comparable](arr []T) T {
+	count := make(map[T]int)
 	maxCount := 0
-	var mostCommon string
+	var mostCommon T
 	for _, v := range arr {
 		count[v]++
 		if count[v] > maxCount {
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+var weatherStart = time.Date(2024, 5, 13, 0, 0, 0, 0, time.UTC)
+
+// testEntry is a forecast entry at weatherStart plus offset.
+func testEntry(offset time.Duration, temp, pop float64, description string) forecastEntry {
+	var e forecastEntry
+	e.Dt = weatherStart.Add(offset).Unix()
+	e.Main.Temp = temp
+	e.Pop = pop
+	e.Weather = append(e.Weather, struct {
+		ID          int    `json:"id"`
+		Description string `json:"description"`
+		Icon        string `json:"icon"`
+	}{Description: description})
+	return e
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestInterpolateAt(t *testing.T) {
+	list := []forecastEntry{
+		testEntry(0, 10, 0, "ясно"),
+		testEntry(3*time.Hour, 16, 0.6, "дождь"),
+	}
+	for _, tc := range []struct {
+		name        string
+		list        []forecastEntry
+		at          time.Duration
+		ok          bool
+		temp, pop   float64
+		description string
+	}{
+		{name: "before the first entry", list: list, at: -time.Hour, ok: true, temp: 10, pop: 0, description: "ясно"},
+		{name: "on the first entry", list: list, at: 0, ok: true, temp: 10, pop: 0, description: "ясно"},
+		{name: "a third of the way", list: list, at: time.Hour, ok: true, temp: 12, pop: 0.2, description: "ясно"},
+		{name: "closer to the next entry", list: list, at: 2 * time.Hour, ok: true, temp: 14, pop: 0.4, description: "дождь"},
+		{name: "on the last entry", list: list, at: 3 * time.Hour, ok: true, temp: 16, pop: 0.6, description: "дождь"},
+		{name: "after the last entry", list: list, at: 4 * time.Hour},
+		{name: "no entries", at: 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			at := weatherStart.Add(tc.at)
+			p, ok := interpolateAt(tc.list, at)
+			if ok != tc.ok {
+				t.Fatalf("ok = %v, want %v", ok, tc.ok)
+			}
+			if !ok {
+				return
+			}
+			if !p.Time.Equal(at) || !near(p.Temp, tc.temp) || !near(p.Pop, tc.pop) || p.Description != tc.description {
+				t.Errorf("got %+v, want temp %v, pop %v, %q at %v", p, tc.temp, tc.pop, tc.description, at)
+			}
+		})
+	}
+}
+
+func TestNextHours(t *testing.T) {
+	data := &forecastResponse{}
+	data.City.Timezone = 3 * 3600
+	for i := 0; i < 4; i++ {
+		data.List = append(data.List, testEntry(time.Duration(i)*forecastStep, float64(i*3), 0, "облачно"))
+	}
+	// 03:30 local time; the entries cover up to 12:00 local.
+	now := weatherStart.Add(30 * time.Minute)
+
+	for _, tc := range []struct {
+		hours     int
+		wantHours []int // local hours of the points
+	}{
+		{hours: 1, wantHours: []int{4}},
+		{hours: 3, wantHours: []int{4, 5, 6}},
+		{hours: 12, wantHours: []int{4, 5, 6, 7, 8, 9, 10, 11, 12}},
+	} {
+		points := nextHours(data, now, tc.hours)
+		if len(points) != len(tc.wantHours) {
+			t.Errorf("%d hours: got %d points, want %d", tc.hours, len(points), len(tc.wantHours))
+			continue
+		}
+		for i, p := range points {
+			local := p.Time.In(data.location())
+			if local.Hour() != tc.wantHours[i] || local.Minute() != 0 {
+				t.Errorf("%d hours: point %d at %s, want %02d:00", tc.hours, i, local.Format("15:04"), tc.wantHours[i])
+			}
+			// The temperature rises by a degree an hour.
+			if want := float64(local.Hour() - 3); !near(p.Temp, want) {
+				t.Errorf("%d hours: %.2f°C at %s, want %.2f", tc.hours, p.Temp, local.Format("15:04"), want)
+			}
+		}
+	}
+}
+
+// localDay is a forecast at UTC+3 with an entry every 3 hours from 03:00
+// local time on 13 May to 00:00 on the 14th.
+func localDay() *forecastResponse {
+	data := &forecastResponse{}
+	data.City.Timezone = 3 * 3600
+	temps := []float64{8, 10, 14, 20, 22, 17, 12, 9}
+	for i, temp := range temps {
+		e := testEntry(time.Duration(i)*forecastStep, temp, 0.1, "облачно")
+		e.Wind.Speed = 4
+		data.List = append(data.List, e)
+	}
+	data.List[3].Rain.Volume, data.List[3].Pop = 1, 0.8 // 12:00
+	data.List[4].Rain.Volume, data.List[4].Pop = 0.5, 0.6
+	data.List[4].Wind.Gust = 12
+	data.List[4].Weather[0].Description = "дождь"
+	data.List[6].Condition = conditionThunderstorm // 21:00
+	return data
+}
+
+func TestSummarizeDays(t *testing.T) {
+	days := summarizeDays(localDay())
+	if len(days) != 2 {
+		t.Fatalf("got %d days, want 2", len(days))
+	}
+
+	d := days[0]
+	if got := d.Date.Format("2006-01-02 15:04 -0700"); got != "2024-05-13 00:00 +0300" {
+		t.Errorf("date %s", got)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"min", d.Min, 8},
+		{"max", d.Max, 22},
+		{"day max", d.DayMax, 22},
+		{"night min", d.NightMin, 8},
+		{"precipitation", d.Precip, 1.5},
+		{"pop", d.Pop, 0.8},
+		{"gusts", d.GustMax, 12},
+	} {
+		if !near(c.got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+	if !d.HasDay || !d.HasNight {
+		t.Errorf("has day %v, has night %v; want both", d.HasDay, d.HasNight)
+	}
+	if d.Description != "облачно" {
+		t.Errorf("description %q, want the most frequent one", d.Description)
+	}
+	var windows []string
+	for _, w := range d.Windows {
+		windows = append(windows, w.String())
+	}
+	if want := []string{"дождь с 12 до 18", "гроза с 21 до 24"}; !slices.Equal(windows, want) {
+		t.Errorf("windows %q, want %q", windows, want)
+	}
+
+	next := days[1]
+	if next.HasDay || !next.HasNight || !near(next.NightMin, 9) || len(next.Windows) != 0 {
+		t.Errorf("second day: %+v", next)
+	}
+}
+
+func TestPrecipWindows(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		kinds string // one letter per 3 hours from 03:00: r rain, s snow, . dry
+		want  []string
+	}{
+		{"dry", "........", nil},
+		{"one entry", "r.......", []string{"дождь с 3 до 6"}},
+		{"merged", ".rrr....", []string{"дождь с 6 до 15"}},
+		{"gap", "r.r.....", []string{"дождь с 3 до 6", "дождь с 9 до 12"}},
+		{"kind changes", "rs......", []string{"дождь с 3 до 6", "снег с 6 до 9"}},
+		{"till midnight", "......r.", []string{"дождь с 21 до 24"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			data := localDay()
+			for i := range data.List {
+				e := &data.List[i]
+				e.Rain.Volume, e.Snow.Volume, e.Condition = 0, 0, conditionUnknown
+				switch tc.kinds[i] {
+				case 'r':
+					e.Rain.Volume = 1
+				case 's':
+					e.Snow.Volume = 1
+				}
+			}
+			var got []string
+			for _, w := range summarizeDays(data)[0].Windows {
+				got = append(got, w.String())
+			}
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("got %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Errors of the weather API, matched with errors.Is. Every failed call
+// returns an *apiError wrapping one of them.
+var (
+	errCityNotFound = errors.New("город не найден")
+	errUnauthorized = errors.New("ключ API погоды отклонён")
+	errRateLimited  = errors.New("превышен лимит запросов к API погоды")
+	errUpstream     = errors.New("сервис погоды недоступен")
+)
+
+// apiError is a failed weather API call. Status is 0 when there was no
+// response at all.
+type apiError struct {
+	Endpoint string
+	Status   int
+	Message  string
+	kind     error
+}
+
+func (e *apiError) Error() string {
+	if e.Status == 0 {
+		return fmt.Sprintf("%s: %s: %s", e.Endpoint, e.kind, e.Message)
+	}
+	return fmt.Sprintf("%s: %s (HTTP %d: %s)", e.Endpoint, e.kind, e.Status, e.Message)
+}
+
+func (e *apiError) Unwrap() error {
+	return e.kind
+}
+
+// errorKind maps an HTTP status to the error the callers check for. Only
+// the endpoints looked up by city name answer 404 for an unknown city; on
+// the others it is an upstream fault.
+func errorKind(endpoint string, status int) error {
+	switch {
+	case status == http.StatusNotFound && (endpoint == "weather" || endpoint == "forecast"):
+		return errCityNotFound
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return errUnauthorized
+	case status == http.StatusTooManyRequests:
+		return errRateLimited
+	}
+	return errUpstream
+}
+
+// newWeatherClient returns the HTTP client shared by all weather API
+// calls. timeout bounds a whole call, body included; the transport limits
+// set the stages, so a stuck connect fails long before that.
+func newWeatherClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}).DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+		},
+	}
+}
+
+// get requests an OpenWeatherMap endpoint with a key from the quota and
+// decodes the JSON answer into v. Non-2xx answers become an *apiError
+// carrying the message from the body, e.g. {"cod":"404","message":"city
+// not found"}. Calls are recorded in the metrics, the health checks and
+// the log; the URL holds the API key and is never logged.
+func (p *owmProvider) get(ctx context.Context, endpoint, url string, v any) error {
+	key, err := p.quota.acquire(ctx, endpoint)
+	if err != nil {
+		if errors.Is(err, errRateLimited) {
+			return &apiError{Endpoint: endpoint, Message: "исчерпана квота запросов", kind: errRateLimited}
+		}
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url+"&appid="+key, nil)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	observeWeatherRequest(endpoint, start, resp, err)
+	logger := slog.With("provider", "openweathermap", "endpoint", endpoint)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// *url.Error repeats the URL with the key, keep only the cause.
+		cause := err
+		if inner := errors.Unwrap(err); inner != nil {
+			cause = inner
+		}
+		apiErr := &apiError{Endpoint: endpoint, Message: cause.Error(), kind: errUpstream}
+		health.recordWeatherCall(endpoint, apiErr)
+		logger.Warn("Ошибка запроса погоды", "err", apiErr)
+		return apiErr
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var body struct {
+			Message string `json:"message"`
+		}
+		_ = json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body)
+		apiErr := &apiError{Endpoint: endpoint, Status: resp.StatusCode, Message: body.Message, kind: errorKind(endpoint, resp.StatusCode)}
+		// One Call answers 401 to keys without its subscription; the key
+		// itself is fine for the other endpoints.
+		if endpoint != "onecall" || resp.StatusCode != http.StatusUnauthorized {
+			p.quota.report(key, resp.StatusCode)
+		}
+		health.recordWeatherCall(endpoint, apiErr)
+		if errors.Is(apiErr, errCityNotFound) {
+			logger.Debug("Запрос погоды", "status", resp.StatusCode, "err", apiErr)
+		} else {
+			logger.Warn("Ошибка запроса погоды", "status", resp.StatusCode, "err", apiErr)
+		}
+		return apiErr
+	}
+	logger.Debug("Запрос погоды", "status", resp.StatusCode, "duration", time.Since(start))
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		apiErr := &apiError{Endpoint: endpoint, Status: resp.StatusCode, Message: "неверный ответ: " + err.Error(), kind: errUpstream}
+		health.recordWeatherCall(endpoint, apiErr)
+		return apiErr
+	}
+	health.recordWeatherCall(endpoint, nil)
+	return nil
+}
+
+// userMessage explains a failed weather request to the user.
+func userMessage(err error) string {
+	switch {
+	case errors.Is(err, errCityNotFound):
+		return "Город не найден. Проверьте название или выберите город заново."
+	case errors.Is(err, errRateLimited):
+		return "Сервис погоды перегружен запросами. Попробуйте через минуту."
+	case errors.Is(err, errUnauthorized), errors.Is(err, errUpstream):
+		return "Сервис погоды сейчас недоступен. Попробуйте позже."
+	}
+	return "Не удалось получить прогноз. Попробуйте позже."
+}
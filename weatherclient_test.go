@@ -0,0 +1,29 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestErrorKind(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint string
+		status   int
+		want     error
+	}{
+		{"weather", http.StatusNotFound, errCityNotFound},
+		{"forecast", http.StatusNotFound, errCityNotFound},
+		{"onecall", http.StatusNotFound, errUpstream},
+		{"air_pollution", http.StatusNotFound, errUpstream},
+		{"weather", http.StatusUnauthorized, errUnauthorized},
+		{"onecall", http.StatusForbidden, errUnauthorized},
+		{"forecast", http.StatusTooManyRequests, errRateLimited},
+		{"weather", http.StatusInternalServerError, errUpstream},
+		{"weather", http.StatusBadGateway, errUpstream},
+		{"weather", http.StatusBadRequest, errUpstream},
+	} {
+		if got := errorKind(tc.endpoint, tc.status); got != tc.want {
+			t.Errorf("errorKind(%q, %d) = %v, want %v", tc.endpoint, tc.status, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Webhook settings (WebhookConfig):
+//
+//	url     public https URL Telegram posts updates to; enables webhook mode
+//	listen  address of the built-in server
+//	secret  expected X-Telegram-Bot-Api-Secret-Token, random if empty
+//	cert    self-signed certificate to upload to Telegram
+//	key     its key; with cert the server speaks TLS itself
+const secretTokenHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+// webhookServer receives updates over HTTP instead of long polling.
+type webhookServer struct {
+	server  *http.Server
+	secret  string
+	updates chan tgbotapi.Update
+	closing chan struct{}
+}
+
+func startWebhook(cfg WebhookConfig) (*webhookServer, error) {
+	hookURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("некорректный адрес вебхука: %w", err)
+	}
+
+	secret := cfg.Secret
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secret = hex.EncodeToString(b)
+	}
+
+	path := hookURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	wh := &webhookServer{
+		secret:  secret,
+		updates: make(chan tgbotapi.Update, bot.Buffer),
+		closing: make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, wh.handle)
+	wh.server = &http.Server{Addr: cfg.Listen, Handler: mux}
+
+	go func() {
+		var err error
+		if cfg.Cert != "" && cfg.Key != "" {
+			err = wh.server.ListenAndServeTLS(cfg.Cert, cfg.Key)
+		} else {
+			err = wh.server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			fatal("Ошибка сервера вебхука", "err", err)
+		}
+	}()
+
+	if err := setWebhook(hookURL.String(), secret, cfg.Cert); err != nil {
+		wh.server.Close()
+		return nil, err
+	}
+	slog.Info("Вебхук установлен", "url", hookURL.Redacted(), "listen", cfg.Listen)
+	return wh, nil
+}
+
+// setWebhook registers the webhook with the secret token. The library's
+// WebhookConfig has no secret_token field, hence the raw request.
+func setWebhook(hookURL, secret, certFile string) error {
+	params := tgbotapi.Params{}
+	params["url"] = hookURL
+	params["secret_token"] = secret
+
+	var err error
+	if certFile != "" {
+		files := []tgbotapi.RequestFile{{Name: "certificate", Data: tgbotapi.FilePath(certFile)}}
+		_, err = bot.UploadFiles("setWebhook", params, files)
+	} else {
+		_, err = bot.MakeRequest("setWebhook", params)
+	}
+	if err != nil {
+		return fmt.Errorf("setWebhook: %w", err)
+	}
+	return nil
+}
+
+func (wh *webhookServer) handle(w http.ResponseWriter, r *http.Request) {
+	got := r.Header.Get(secretTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(got), []byte(wh.secret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	update, err := bot.HandleUpdate(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	health.recordUpdates()
+	select {
+	case wh.updates <- *update:
+	case <-wh.closing:
+		// Not acknowledged, so Telegram delivers it again after restart.
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	}
+}
+
+// Close removes the webhook from Telegram and stops the server, letting
+// requests in progress finish until ctx expires. The updates they queued
+// were already answered, so the channel is closed only once no handler can
+// add to it and is left to be drained.
+func (wh *webhookServer) Close(ctx context.Context) error {
+	close(wh.closing)
+	if _, err := bot.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+		slog.Warn("Ошибка deleteWebhook", "err", err)
+	}
+	if err := wh.server.Shutdown(ctx); err != nil {
+		return err
+	}
+	close(wh.updates)
+	return nil
+}